@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// requireAdminToken guards the /admin routes, which are only registered when
+// ADMIN_TOKEN is set. The token is sent as an Authorization bearer token and
+// compared in constant time.
+func requireAdminToken(ctx context.Context, c *app.RequestContext) {
+	if !hasAdminToken(c) {
+		respond(c, consts.StatusUnauthorized, map[string]interface{}{
+			"error": "A valid admin token is required",
+		})
+		c.Abort()
+		return
+	}
+	c.Next(ctx)
+}
+
+// hasAdminToken reports whether the request carries ADMIN_TOKEN as its
+// Authorization bearer token; never when no admin token is configured
+func hasAdminToken(c *app.RequestContext) bool {
+	token := strings.TrimPrefix(string(c.GetHeader("Authorization")), "Bearer ")
+	return cfg.AdminToken != "" && validToken(token, hashToken(cfg.AdminToken))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// ALPHA_POLICY values: what happens to uploads with an alpha channel
+const (
+	alphaAllow   = "allow"   // keep transparency
+	alphaFlatten = "flatten" // composite onto ALPHA_BACKGROUND
+	alphaReject  = "reject"  // refuse the upload
+)
+
+// hasAlpha reports whether an image has an alpha channel
+func hasAlpha(img *bimg.Image) bool {
+	meta, err := img.Metadata()
+	return err == nil && meta.Alpha
+}
+
+// checkAlphaPolicy rejects images with an alpha channel under ALPHA_POLICY=reject
+func checkAlphaPolicy(data []byte) error {
+	if cfg.AlphaPolicy == alphaReject && hasAlpha(bimg.NewImage(data)) {
+		return &httpError{consts.StatusBadRequest, "Uploaded image has an alpha channel (transparency), which is not accepted"}
+	}
+	return nil
+}
+
+// flattenColor returns the background to pass to bimg to flatten onto c.
+// bimg treats black as "no background" and skips flattening, so pure black
+// is nudged to a colour that is visually the same.
+func flattenColor(c bimg.Color) bimg.Color {
+	if c == bimg.ColorBlack {
+		return bimg.Color{R: 0, G: 0, B: 1}
+	}
+	return c
+}
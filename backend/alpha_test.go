@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/png"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+func TestAlphaPolicy(t *testing.T) {
+	tests := []struct {
+		policy    string
+		status    int
+		wantAlpha bool
+	}{
+		{alphaAllow, consts.StatusOK, true},
+		{alphaFlatten, consts.StatusOK, false},
+		{alphaReject, consts.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			mem := setupTestServer(t, map[string]string{"ALPHA_POLICY": tt.policy})
+			engine := newTestEngine()
+			engine.POST("/upload", handleImageUpload)
+
+			w := postImage(engine, "/upload", "transparent.png", testPNG(t, 40, 40, 128))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status != consts.StatusOK {
+				assertStored(t, mem)
+				return
+			}
+			names := mem.Names()
+			if len(names) != 1 {
+				t.Fatalf("stored files = %v, want one", names)
+			}
+			stored, _ := mem.Read(names[0])
+			if got := hasAlpha(bimg.NewImage(stored)); got != tt.wantAlpha {
+				t.Errorf("stored image has alpha = %v, want %v", got, tt.wantAlpha)
+			}
+		})
+	}
+}
+
+func TestAlphaPolicyIgnoresOpaqueImages(t *testing.T) {
+	setupTestServer(t, map[string]string{"ALPHA_POLICY": alphaReject})
+	if err := checkAlphaPolicy(testJPEG(t, 16, 16)); err != nil {
+		t.Errorf("opaque JPEG rejected: %v", err)
+	}
+}
+
+func TestAlphaFlattenBackground(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  uint8 // the red channel of the flattened pixels
+	}{
+		{"alpha background", "", 0xff},
+		{"explicit black", "?bg=000000", 0x00},
+		{"explicit colour", "?bg=808080", 0x80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := setupTestServer(t, map[string]string{"ALPHA_POLICY": alphaFlatten, "ALPHA_BACKGROUND": "ffffff"})
+			engine := newTestEngine()
+			engine.POST("/upload", handleImageUpload)
+
+			w := postImage(engine, "/upload"+tt.query, "transparent.png", testPNG(t, 40, 40, 0))
+			if w.Code != consts.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+			names := mem.Names()
+			if len(names) != 1 {
+				t.Fatalf("stored files = %v, want one", names)
+			}
+			stored, _ := mem.Read(names[0])
+			img, _, err := image.Decode(bytes.NewReader(stored))
+			if err != nil {
+				t.Fatalf("stored image doesn't decode: %v", err)
+			}
+			r, _, _, a := img.At(20, 20).RGBA()
+			if got := int(r >> 8); a>>8 != 0xff || got < int(tt.want)-2 || got > int(tt.want)+2 {
+				t.Errorf("flattened pixel red = %#x, alpha %#x; want %#x, opaque", got, a>>8, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// defaultSweepQualities are the qualities tried when a sweep names none
+var defaultSweepQualities = []int{90, 80, 70, 60, 50}
+
+// maxSweepQualities bounds how many encodes one sweep request may ask for
+const maxSweepQualities = 10
+
+// parseSweepQualities parses the comma-separated qualities parameter
+func parseSweepQualities(s string) ([]int, error) {
+	if s == "" {
+		return defaultSweepQualities, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) > maxSweepQualities {
+		return nil, fmt.Errorf("at most %d qualities per sweep", maxSweepQualities)
+	}
+	qualities := make([]int, 0, len(parts))
+	for _, part := range parts {
+		q, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || q < 1 || q > 100 {
+			return nil, fmt.Errorf("qualities must be integers between 1 and 100")
+		}
+		qualities = append(qualities, q)
+	}
+	return qualities, nil
+}
+
+// handleQualitySweep encodes an uploaded image at several qualities and
+// reports the size of each result, to help pick a quality setting. Nothing
+// is stored. The sweep stops once PROCESSING_TIMEOUT has elapsed, returning
+// the qualities finished so far.
+func handleQualitySweep(ctx context.Context, c *app.RequestContext) {
+	_, data, err := readUploadedImage(ctx, c)
+	if err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	opts, err := parseUploadOptions(c)
+	if err != nil {
+		respond(c, errorStatus(err), errorResponse(err))
+		return
+	}
+	qualities, err := parseSweepQualities(c.Query("qualities"))
+	if err != nil {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := validateImageData(data); err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.ProcessingTimeout)
+	defer cancel()
+	if err := pool.Acquire(ctx); err != nil {
+		respond(c, consts.StatusServiceUnavailable, map[string]interface{}{
+			"error": "Timed out waiting for a worker",
+		})
+		return
+	}
+	defer pool.Release()
+
+	// An encode can't be interrupted, so the deadline is checked between them
+	started := time.Now()
+	results := make([]map[string]interface{}, 0, len(qualities))
+	for _, quality := range qualities {
+		if ctx.Err() != nil {
+			break
+		}
+		opts.Quality = quality
+		compressed, err := compressWithFallback(ctx, data, opts)
+		if err != nil {
+			respond(c, consts.StatusInternalServerError, map[string]interface{}{
+				"error": fmt.Sprintf("Failed to compress image: %v", err),
+			})
+			return
+		}
+		result := map[string]interface{}{
+			"quality": quality,
+			"size":    len(compressed),
+			"format":  bimg.DetermineImageTypeName(compressed),
+		}
+		if dims, err := bimg.Size(compressed); err == nil {
+			result["dimensions"] = map[string]interface{}{
+				"width":  dims.Width,
+				"height": dims.Height,
+			}
+		}
+		results = append(results, result)
+	}
+
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"original_size": len(data),
+		"results":       results,
+		"complete":      len(results) == len(qualities),
+		"elapsed_ms":    time.Since(started).Milliseconds(),
+	})
+}
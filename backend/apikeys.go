@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// apiKey holds the settings of one API key from API_KEYS_FILE. Its values
+// are defaults and limits for the uploads made with the key.
+type apiKey struct {
+	// Name identifies the key's owner in the audit log
+	Name string
+	// DefaultQuality is the first quality the size search tries; zero keeps 80
+	DefaultQuality int
+	// AllowedFormats restricts the output format; empty allows any
+	AllowedFormats map[bimg.ImageType]bool
+	// MaxSize caps the upload size below MAX_UPLOAD_SIZE; zero keeps it
+	MaxSize int
+	// Namespace prefixes the key's stored filenames
+	Namespace string
+}
+
+// apiKeyEntry is an API_KEYS_FILE entry as written in the file
+type apiKeyEntry struct {
+	Name           string   `json:"name"`
+	DefaultQuality int      `json:"default_quality"`
+	AllowedFormats []string `json:"allowed_formats"`
+	MaxSize        string   `json:"max_size"`
+	Namespace      string   `json:"namespace"`
+}
+
+// namespacePattern is what a namespace may contain, so it is always safe
+// in a filename
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// loadAPIKeys reads API_KEYS_FILE, a JSON object mapping each key to its
+// settings. Keys are indexed by their hash, like deletion tokens, so
+// looking one up doesn't compare the secret itself.
+func loadAPIKeys(path string) (map[string]apiKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]apiKeyEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no keys defined")
+	}
+
+	keys := make(map[string]apiKey, len(entries))
+	for secret, entry := range entries {
+		if secret == "" {
+			return nil, errors.New("empty key")
+		}
+		name := entry.Name
+		if name == "" {
+			name = "unnamed"
+		}
+		key := apiKey{Name: name, DefaultQuality: entry.DefaultQuality, Namespace: entry.Namespace}
+		if key.DefaultQuality != 0 && (key.DefaultQuality < 20 || key.DefaultQuality > 100) {
+			return nil, fmt.Errorf("key %s: default_quality must be between 20 and 100", name)
+		}
+		if len(entry.AllowedFormats) > 0 {
+			key.AllowedFormats = make(map[bimg.ImageType]bool)
+			for _, f := range entry.AllowedFormats {
+				format, err := parseOutputFormat(f)
+				if err != nil {
+					return nil, fmt.Errorf("key %s: %v", name, err)
+				}
+				key.AllowedFormats[format] = true
+			}
+		}
+		if entry.MaxSize != "" {
+			if key.MaxSize, err = parseByteSize(entry.MaxSize); err != nil || key.MaxSize <= 0 {
+				return nil, fmt.Errorf("key %s: invalid max_size: %q (expected a size such as 5MB)", name, entry.MaxSize)
+			}
+		}
+		if key.Namespace != "" && !namespacePattern.MatchString(key.Namespace) {
+			return nil, fmt.Errorf("key %s: invalid namespace: %q (expected up to 32 lowercase letters, digits, - and _)", name, key.Namespace)
+		}
+		keys[hashToken(secret)] = key
+	}
+	return keys, nil
+}
+
+// apiKeyContextKey is where requireAPIKey stores the request's key
+const apiKeyContextKey = "api_key"
+
+// requireAPIKey rejects image-processing requests without a valid API key
+// when API_KEYS_FILE is set, and records the key for requestAPIKey. The key
+// is sent as X-API-Key or as an Authorization bearer token.
+func requireAPIKey(ctx context.Context, c *app.RequestContext) {
+	secret := string(c.GetHeader("X-API-Key"))
+	if secret == "" {
+		secret = strings.TrimPrefix(string(c.GetHeader("Authorization")), "Bearer ")
+	}
+	key, ok := cfg.APIKeys[hashToken(secret)]
+	if secret == "" || !ok {
+		respond(c, consts.StatusUnauthorized, map[string]interface{}{
+			"error": "A valid API key is required",
+		})
+		c.Abort()
+		return
+	}
+	c.Set(apiKeyContextKey, key)
+	c.Next(ctx)
+}
+
+// requestAPIKey returns the API key the request was authenticated with
+func requestAPIKey(c *app.RequestContext) (apiKey, bool) {
+	v, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return apiKey{}, false
+	}
+	key, ok := v.(apiKey)
+	return key, ok
+}
+
+// uploadSizeLimit is the largest upload the request may send: MAX_UPLOAD_SIZE,
+// or its API key's max_size when smaller
+func uploadSizeLimit(c *app.RequestContext) int {
+	if key, ok := requestAPIKey(c); ok && key.MaxSize > 0 && key.MaxSize < cfg.MaxUploadSize {
+		return key.MaxSize
+	}
+	return cfg.MaxUploadSize
+}
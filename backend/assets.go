@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// defaultVariant names the single file of an asset made by a plain upload
+const defaultVariant = "default"
+
+// asset groups the stored files made from one upload, such as the sizes of
+// an icon set, under one ID
+type asset struct {
+	Created  time.Time      `json:"created"`
+	Variants []assetVariant `json:"variants"`
+}
+
+// assetVariant is one stored file of an asset
+type assetVariant struct {
+	Name     string `json:"name"`
+	Filename string `json:"filename"`
+}
+
+// assetIndex maps asset IDs to their manifests and stored files back to
+// their asset. It is persisted as a single JSON file, or kept in memory only
+// when path is empty.
+type assetIndex struct {
+	mu     sync.RWMutex
+	path   string
+	assets map[string]*asset // id -> manifest
+	byName map[string]string // filename -> id
+}
+
+// assets is the asset index in effect, loaded in main
+var assets *assetIndex
+
+// loadAssetIndex opens the index at path, starting empty if it doesn't exist yet
+func loadAssetIndex(path string) (*assetIndex, error) {
+	idx := &assetIndex{path: path, assets: make(map[string]*asset), byName: make(map[string]string)}
+	if path == "" {
+		return idx, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &idx.assets); err != nil {
+		return nil, fmt.Errorf("corrupt asset index %s: %v", path, err)
+	}
+	for id, a := range idx.assets {
+		for _, v := range a.Variants {
+			idx.byName[v.Filename] = id
+		}
+	}
+	return idx, nil
+}
+
+// Add records variants under the asset id, creating it when new. Files
+// already in the asset, as when an upload is deduplicated, aren't repeated.
+func (idx *assetIndex) Add(id string, variants []assetVariant) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	a, ok := idx.assets[id]
+	if !ok {
+		a = &asset{Created: time.Now().UTC()}
+		idx.assets[id] = a
+	}
+	for _, v := range variants {
+		if idx.byName[v.Filename] == id {
+			continue
+		}
+		a.Variants = append(a.Variants, v)
+		idx.byName[v.Filename] = id
+	}
+	return idx.save()
+}
+
+// Get returns a copy of an asset's manifest
+func (idx *assetIndex) Get(id string) (asset, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	a, ok := idx.assets[id]
+	if !ok {
+		return asset{}, false
+	}
+	return asset{Created: a.Created, Variants: append([]assetVariant(nil), a.Variants...)}, true
+}
+
+// AssetOf returns the ID of the asset a stored file belongs to
+func (idx *assetIndex) AssetOf(name string) (string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	id, ok := idx.byName[name]
+	return id, ok
+}
+
+// Remove drops a deleted file from its asset, and the asset once it has no
+// files left
+func (idx *assetIndex) Remove(name string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	id, ok := idx.byName[name]
+	if !ok {
+		return nil
+	}
+	delete(idx.byName, name)
+	a := idx.assets[id]
+	for i, v := range a.Variants {
+		if v.Filename == name {
+			a.Variants = append(a.Variants[:i], a.Variants[i+1:]...)
+			break
+		}
+	}
+	if len(a.Variants) == 0 {
+		delete(idx.assets, id)
+	}
+	return idx.save()
+}
+
+// save writes the index atomically; the caller holds the lock
+func (idx *assetIndex) save() error {
+	if idx.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(idx.assets)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return err
+	}
+	tmp := idx.path + ".tmp"
+	defer os.Remove(tmp) // no-op once renamed
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, idx.path)
+}
+
+// publicAssetURL returns the public URL of an asset's manifest
+func publicAssetURL(ctx context.Context, id string) string {
+	return publicBaseURL(ctx) + "/assets/" + id
+}
+
+// addAsset records the files of a new upload as one asset and adds it to
+// the upload result
+func addAsset(ctx context.Context, id string, variants []assetVariant, result map[string]interface{}) {
+	if err := assets.Add(id, variants); err != nil {
+		// The files are stored and still reachable one by one
+		hlog.CtxWarnf(ctx, "failed to record asset %s: %v", id, err)
+		return
+	}
+	result["asset"] = id
+	result["asset_url"] = publicAssetURL(ctx, id)
+}
+
+// variantsResponse describes an asset's files, with their URLs
+func variantsResponse(ctx context.Context, variants []assetVariant) []map[string]interface{} {
+	list := make([]map[string]interface{}, len(variants))
+	for i, v := range variants {
+		list[i] = map[string]interface{}{
+			"name":     v.Name,
+			"filename": v.Filename,
+			"url":      storedFileURL(ctx, v.Filename),
+		}
+	}
+	return list
+}
+
+// handleGetAsset returns an asset's manifest
+func handleGetAsset(ctx context.Context, c *app.RequestContext) {
+	id := c.Param("id")
+	a, ok := assets.Get(id)
+	if !ok {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "Asset not found",
+		})
+		return
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"id":       id,
+		"created":  a.Created,
+		"variants": variantsResponse(ctx, a.Variants),
+	})
+}
+
+// handleDeleteAsset deletes every file of an asset when ?token= matches the
+// deletion token returned for any of them, as they were all uploaded together
+func handleDeleteAsset(ctx context.Context, c *app.RequestContext) {
+	id := c.Param("id")
+	a, ok := assets.Get(id)
+	if !ok {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "Asset not found",
+		})
+		return
+	}
+	authorized := false
+	for _, v := range a.Variants {
+		record, _, err := meta.Get(v.Filename)
+		if err != nil {
+			respond(c, consts.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to read upload metadata",
+			})
+			return
+		}
+		if validToken(c.Query("token"), record.DeleteTokenHash) {
+			authorized = true
+			break
+		}
+	}
+	if !authorized {
+		respond(c, consts.StatusForbidden, map[string]interface{}{
+			"error": "Invalid deletion token",
+		})
+		return
+	}
+
+	deleted := make([]string, 0, len(a.Variants))
+	for _, v := range a.Variants {
+		unlock := filenameLocks.Lock(v.Filename)
+		record, _, _ := meta.Get(v.Filename)
+		e := deletedEvent(v.Filename, "requested", record)
+		removeStoredFile(ctx, v.Filename)
+		unlock()
+		events.Emit(e)
+		deleted = append(deleted, v.Filename)
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"deleted": id,
+		"files":   deleted,
+	})
+}
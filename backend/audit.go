@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// auditEntry is one line of the audit log, describing a single upload attempt
+type auditEntry struct {
+	Time           time.Time `json:"time"`
+	ClientIP       string    `json:"client_ip"`
+	Source         string    `json:"source"` // "upload", "import" or "import-zip"
+	OriginalName   string    `json:"original_name,omitempty"`
+	SourceURL      string    `json:"source_url,omitempty"`
+	APIKey         string    `json:"api_key,omitempty"` // the key's name, never the key
+	Filename       string    `json:"filename,omitempty"`
+	OriginalSize   int       `json:"original_size"`
+	CompressedSize int       `json:"compressed_size,omitempty"`
+	SHA256         string    `json:"sha256,omitempty"` // of the received bytes
+	Status         int       `json:"status"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// auditLog appends JSON lines to a file, rotating it once it would grow past
+// maxSize. Rotated files are kept as path.1 (newest) to path.<backups>.
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+// audit is the audit log in effect, or nil when AUDIT_LOG_FILE is unset
+var audit *auditLog
+
+// openAuditLog opens the audit log at path for appending
+func openAuditLog(path string, maxSize, backups int) (*auditLog, error) {
+	a := &auditLog{path: path, maxSize: int64(maxSize), backups: backups}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// open (re)opens the log file and picks up its current size
+func (a *auditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.size = f, info.Size()
+	return nil
+}
+
+// Record appends an entry. It is a no-op when auditing is disabled.
+func (a *auditLog) Record(entry auditEntry) {
+	if a == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		hlog.Errorf("audit: failed to encode entry: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			hlog.Errorf("audit: failed to rotate %s: %v", a.path, err)
+			if a.file == nil {
+				return
+			}
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		hlog.Errorf("audit: failed to write %s: %v", a.path, err)
+	}
+}
+
+// rotate shifts path.N up by one, moves the current file to path.1 and
+// starts a new one; the caller holds the lock
+func (a *auditLog) rotate() error {
+	a.file.Close()
+	a.file = nil
+	if a.backups == 0 {
+		if err := os.Remove(a.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		for i := a.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+		}
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			return err
+		}
+	}
+	return a.open()
+}
+
+// newAuditEntry describes the outcome of one upload attempt. data and
+// result may be nil when the attempt failed before they were available.
+func newAuditEntry(clientIP, source string, data []byte, result map[string]interface{}, err error) auditEntry {
+	entry := auditEntry{
+		Time:         time.Now().UTC(),
+		ClientIP:     clientIP,
+		Source:       source,
+		OriginalSize: len(data),
+		Status:       consts.StatusOK,
+	}
+	if data != nil {
+		sum := sha256.Sum256(data)
+		entry.SHA256 = hex.EncodeToString(sum[:])
+	}
+	if filename, ok := result["filename"].(string); ok {
+		entry.Filename = filename
+	}
+	if size, ok := result["compressed_size"].(int); ok {
+		entry.CompressedSize = size
+	}
+	if err != nil {
+		entry.Status, entry.Error = errorStatus(err), err.Error()
+	}
+	return entry
+}
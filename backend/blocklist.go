@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math/bits"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// phashBlocklist holds the perceptual hashes of known-bad images from
+// PHASH_BLOCKLIST_FILE. Images within PHASH_BLOCKLIST_DISTANCE bits of any
+// of them are refused.
+type phashBlocklist struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	hashes  []uint64
+}
+
+// blocklist is the blocklist in effect, or nil when PHASH_BLOCKLIST_FILE is unset
+var blocklist *phashBlocklist
+
+// loadBlocklist reads the blocklist at path
+func loadBlocklist(path string) (*phashBlocklist, error) {
+	b := &phashBlocklist{path: path}
+	if _, err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload rereads the file and returns how many hashes are loaded. An
+// invalid file keeps the current hashes; its version is still remembered,
+// so the watcher reports it once rather than on every check.
+func (b *phashBlocklist) Reload() (int, error) {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return 0, err
+	}
+	hashes, err := parseBlocklist(data)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.modTime = info.ModTime()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", b.path, err)
+	}
+	b.hashes = hashes
+	return len(hashes), nil
+}
+
+// parseBlocklist parses one 16-hex-digit hash per line, as returned in the
+// phash upload field. Blank lines and text after a # are ignored, as is
+// anything after the hash, such as a label.
+func parseBlocklist(data []byte) ([]uint64, error) {
+	var hashes []uint64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		hash, err := parsePHash(strings.ToLower(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid hash %q", line, fields[0])
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, scanner.Err()
+}
+
+// Blocked reports whether hash is within distance bits of a blocked hash
+func (b *phashBlocklist) Blocked(hash uint64, distance int) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, blocked := range b.hashes {
+		if bits.OnesCount64(blocked^hash) <= distance {
+			return true
+		}
+	}
+	return false
+}
+
+// changed reports whether the file was modified since it was last loaded
+func (b *phashBlocklist) changed() bool {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return !info.ModTime().Equal(b.modTime)
+}
+
+// watch reloads the blocklist whenever its file changes, checking every interval
+func (b *phashBlocklist) watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !b.changed() {
+				continue
+			}
+			if n, err := b.Reload(); err != nil {
+				hlog.Errorf("phash blocklist: reload failed, keeping the previous list: %v", err)
+			} else {
+				hlog.Infof("phash blocklist: reloaded %d hashes", n)
+			}
+		}
+	}()
+}
+
+// checkBlocklist refuses images perceptually close to a blocked image.
+// The message doesn't say which entry matched.
+func checkBlocklist(data []byte) error {
+	if blocklist == nil {
+		return nil
+	}
+	hash, err := perceptualHash(data)
+	if err != nil {
+		return &httpError{consts.StatusInternalServerError, "Failed to check the image against the blocklist"}
+	}
+	if blocklist.Blocked(hash, cfg.PHashBlocklistDistance) {
+		return &httpError{consts.StatusForbidden, "Uploaded image is not allowed"}
+	}
+	return nil
+}
+
+// handleReloadBlocklist rereads PHASH_BLOCKLIST_FILE on demand
+func handleReloadBlocklist(ctx context.Context, c *app.RequestContext) {
+	if blocklist == nil {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "No blocklist is configured",
+		})
+		return
+	}
+	n, err := blocklist.Reload()
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": fmt.Sprintf("Failed to reload blocklist: %v", err),
+		})
+		return
+	}
+	hlog.CtxInfof(ctx, "phash blocklist: reloaded %d hashes", n)
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"hashes": n,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// startCleanup runs the expired-upload sweep every interval in the background
+func startCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if removed := sweepExpired(now); removed > 0 {
+				hlog.Infof("cleanup: removed %d expired uploads", removed)
+			}
+		}
+	}()
+}
+
+// sweepExpired deletes every stored file whose expiry is before now and
+// returns how many were removed
+func sweepExpired(now time.Time) int {
+	files, err := store.List()
+	if err != nil {
+		hlog.Errorf("cleanup: failed to list uploads: %v", err)
+		return 0
+	}
+
+	removed := 0
+	for _, file := range files {
+		record, _, err := meta.Get(file.Name)
+		if err != nil {
+			hlog.Warnf("cleanup: failed to read metadata for %s: %v", file.Name, err)
+			continue
+		}
+		expiresAt, ok := fileExpiry(file, record)
+		if !ok || now.Before(expiresAt) {
+			continue
+		}
+
+		if err := store.Delete(file.Name); err != nil && err != errNotFound {
+			hlog.Errorf("cleanup: failed to delete %s: %v", file.Name, err)
+			continue
+		}
+		deleted := deletedEvent(file.Name, "expired", record)
+		if err := meta.Delete(file.Name); err != nil {
+			hlog.Warnf("cleanup: failed to delete metadata for %s: %v", file.Name, err)
+		}
+		if err := phashes.Remove(file.Name); err != nil {
+			hlog.Warnf("cleanup: failed to update phash index for %s: %v", file.Name, err)
+		}
+		if err := shortIDs.Remove(file.Name); err != nil {
+			hlog.Warnf("cleanup: failed to update short ID index for %s: %v", file.Name, err)
+		}
+		if err := assets.Remove(file.Name); err != nil {
+			hlog.Warnf("cleanup: failed to update asset index for %s: %v", file.Name, err)
+		}
+		events.Emit(deleted)
+		removed++
+	}
+	return removed
+}
+
+// fileExpiry returns when a stored file expires: its own expiry when one was
+// set at upload, otherwise its modification time plus the global UPLOAD_TTL.
+// It returns false when the file never expires.
+func fileExpiry(file fileInfo, record fileMeta) (time.Time, bool) {
+	if record.ExpiresAt != nil {
+		return *record.ExpiresAt, true
+	}
+	if cfg.UploadTTL > 0 {
+		return file.ModTime.Add(cfg.UploadTTL), true
+	}
+	return time.Time{}, false
+}
+
+// parseExpiresIn parses the expires_in upload parameter, given either as a
+// duration ("36h", "90m") or a number of seconds. Values above
+// MAX_EXPIRES_IN are capped to it.
+func parseExpiresIn(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(s)
+		if convErr != nil {
+			return 0, fmt.Errorf("expires_in must be a duration such as 24h or a number of seconds")
+		}
+		if seconds > int(cfg.MaxExpiresIn/time.Second) {
+			seconds = int(cfg.MaxExpiresIn / time.Second)
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("expires_in must be positive")
+	}
+	if d > cfg.MaxExpiresIn {
+		d = cfg.MaxExpiresIn
+	}
+	return d, nil
+}
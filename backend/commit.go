@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// encodeCommitToken builds the opaque commit token returned for a pending
+// upload. It carries the filename so committing needs nothing else; only the
+// secret's hash is stored.
+func encodeCommitToken(filename, secret string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(filename + "/" + secret))
+}
+
+// decodeCommitToken splits a commit token into its filename and secret
+func decodeCommitToken(token string) (filename, secret string, ok bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", false
+	}
+	i := strings.LastIndexByte(string(raw), '/')
+	if i <= 0 || i == len(raw)-1 {
+		return "", "", false
+	}
+	return string(raw[:i]), string(raw[i+1:]), true
+}
+
+// handleCommitUpload commits a pending upload given ?token=, the commit
+// token returned at upload. The file then keeps the expiry it was uploaded
+// with instead of being deleted at the end of the grace period. Committing
+// an already committed upload succeeds without changing it.
+func handleCommitUpload(ctx context.Context, c *app.RequestContext) {
+	filename, secret, ok := decodeCommitToken(c.Query("token"))
+	if !ok || !validStoredName(filename) {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid commit token",
+		})
+		return
+	}
+	unlock := filenameLocks.Lock(filename)
+	defer unlock()
+
+	exists, err := store.Exists(filename)
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to look up file",
+		})
+		return
+	}
+	if !exists {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "Upload not found or expired",
+		})
+		return
+	}
+	record, _, err := meta.Get(filename)
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to read upload metadata",
+		})
+		return
+	}
+
+	if !validToken(secret, record.CommitTokenHash) {
+		respond(c, consts.StatusForbidden, map[string]interface{}{
+			"error": "Invalid commit token",
+		})
+		return
+	}
+	if record.Pending {
+		record.Pending = false
+		record.ExpiresAt, record.CommittedExpiresAt = record.CommittedExpiresAt, nil
+		if err := meta.Put(filename, record); err != nil {
+			respond(c, consts.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to save upload metadata",
+			})
+			return
+		}
+	}
+
+	result := map[string]interface{}{
+		"filename":  filename,
+		"url":       versionedFileURL(ctx, filename, record.SHA256),
+		"committed": true,
+	}
+	if expiresAt, ok := fileExpiry(fileInfo{Name: filename, ModTime: time.Now()}, record); ok {
+		result["expires_at"] = expiresAt.UTC().Format(time.RFC3339)
+	}
+	respond(c, consts.StatusOK, result)
+}
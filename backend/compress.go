@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// compressibleType reports whether responses of this content type are
+// compressed: the JSON and XML API bodies. Images, including everything
+// served from /uploads, are already compressed and always sent as stored.
+func compressibleType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch mediaType {
+	case "application/json", "application/xml", "text/xml":
+		return true
+	}
+	return false
+}
+
+// negotiateEncoding picks the response encoding from an Accept-Encoding
+// header: br, then gzip, or "" when the client accepts neither
+func negotiateEncoding(acceptEncoding string) string {
+	brQ, gzipQ := 0.0, 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		switch coding {
+		case "br":
+			brQ = q
+		case "gzip", "x-gzip":
+			gzipQ = q
+		}
+	}
+	switch {
+	case brQ > 0 && brQ >= gzipQ:
+		return "br"
+	case gzipQ > 0:
+		return "gzip"
+	}
+	return ""
+}
+
+// compressBody encodes data with the negotiated encoding
+func compressBody(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	if encoding == "br" {
+		w = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressionMiddleware compresses JSON and XML responses of at least
+// RESPONSE_COMPRESSION_MIN_SIZE for clients that accept br or gzip. Bodies
+// that are streamed or already carry a Content-Encoding are left alone.
+func compressionMiddleware(ctx context.Context, c *app.RequestContext) {
+	c.Next(ctx)
+
+	resp := &c.Response
+	if resp.IsBodyStream() || len(resp.Header.ContentEncoding()) > 0 ||
+		!compressibleType(string(resp.Header.ContentType())) {
+		return
+	}
+	body := resp.Body()
+	if len(body) < cfg.ResponseCompressionMinSize {
+		return
+	}
+	// The body depends on Accept-Encoding from here on, whatever this
+	// client accepts, so caches must key on it
+	resp.Header.Add("Vary", "Accept-Encoding")
+	encoding := negotiateEncoding(string(c.GetHeader("Accept-Encoding")))
+	if encoding == "" {
+		return
+	}
+	compressed, err := compressBody(encoding, body)
+	if err != nil || len(compressed) >= len(body) {
+		return
+	}
+	resp.Header.SetContentEncoding(encoding)
+	resp.SetBody(compressed)
+}
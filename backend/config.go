@@ -0,0 +1,810 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/h2non/bimg"
+)
+
+// config holds the service settings resolved from the environment at startup
+type config struct {
+	// CompressionTiers selects the compression target from the original image size.
+	// Empty means every image targets defaultTargetSize.
+	CompressionTiers []compressionTier
+
+	// ForceOutputFormat, unless bimg.UNKNOWN, is the format every upload is
+	// stored as; the format parameter may only override it when
+	// AllowFormatOverride is set
+	ForceOutputFormat   bimg.ImageType
+	AllowFormatOverride bool
+
+	// FormatMap picks the output format by input format (as named by
+	// sniffFormat) when no format parameter is given
+	FormatMap map[string]bimg.ImageType
+
+	// PassthroughSizes stores uploads of these input formats unchanged up to
+	// the given size in bytes
+	PassthroughSizes map[string]int
+
+	// Connection handling passed to the Hertz server. A zero timeout disables it.
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	IdleTimeout          time.Duration
+	KeepAlive            bool
+	HTTP2                bool
+	MaxConcurrentStreams int
+
+	// PublicURL is the base of returned URLs, from PUBLIC_URL, and
+	// PublicURLMode whether it is used ("fixed") or each request's own
+	// scheme and host ("request-derived")
+	PublicURL     string
+	PublicURLMode string
+
+	// RoutePrefix is the path every endpoint is mounted under, such as
+	// "/images"; empty mounts them at the root
+	RoutePrefix string
+
+	// TempDir holds temporary files, such as large uploads spilled to disk
+	TempDir string
+	// TempFileTTL is the age at which leftover temporary files are swept;
+	// zero disables the background sweep
+	TempFileTTL time.Duration
+
+	// AdminToken enables the /admin endpoints, authorized by this bearer token
+	AdminToken string
+
+	// StorageBackend selects where uploads are kept: "disk" or "memory"
+	StorageBackend string
+	// MetadataDir holds per-file sidecar records for the disk backend
+	MetadataDir string
+
+	// LosslessMaxOversize is how far, in percent, lossless WebP output may
+	// exceed the size target before lossy encoding is used instead
+	LosslessMaxOversize int
+
+	// UploadCreatedStatus answers new uploads with 201 Created and a Location
+	// header instead of 200
+	UploadCreatedStatus bool
+
+	// DeleteTokens returns a deletion token with each upload, accepted by
+	// DELETE /uploads/:filename
+	DeleteTokens bool
+
+	// DryRun processes uploads and builds their responses as usual but
+	// writes nothing to storage
+	DryRun bool
+
+	// ServeStatic registers the GET/HEAD /uploads routes; disable it when
+	// another server serves the files, with PUBLIC_URL pointing at it
+	ServeStatic bool
+
+	// UploadsCaseInsensitive redirects /uploads requests to the stored file
+	// whose name matches ignoring case
+	UploadsCaseInsensitive bool
+
+	// UploadsHeaders are added to every /uploads response
+	UploadsHeaders []responseHeader
+	// TimingAllowOrigin is the Timing-Allow-Origin header value for served
+	// files and timed responses; empty sends none
+	TimingAllowOrigin string
+	// ServerTiming reports the stages of processing in a Server-Timing header
+	ServerTiming bool
+	// VersionedURLs adds ?v=<content hash> to returned file URLs
+	VersionedURLs bool
+
+	// MissingImagePlaceholder is served with MissingImageStatus for uploads
+	// that don't exist; empty answers 404
+	MissingImagePlaceholder string
+	MissingImageStatus      int
+
+	// UploadTTL is how long uploads are kept before the cleanup sweep deletes
+	// them; zero keeps them forever unless an upload sets its own expiry
+	UploadTTL       time.Duration
+	CleanupInterval time.Duration
+
+	// LogFormat is "text" or "json"; logs go to stdout at LogLevel and above
+	LogFormat string
+	LogLevel  hlog.Level
+
+	// ScrubInterval is how often every stored file is checked against its
+	// hash; zero disables the background scrub
+	ScrubInterval time.Duration
+	// MaxExpiresIn caps the per-upload expires_in parameter
+	MaxExpiresIn time.Duration
+	// PendingUploads keeps new uploads pending until committed with their
+	// commit token; uncommitted ones are deleted after PendingGracePeriod
+	PendingUploads     bool
+	PendingGracePeriod time.Duration
+
+	// FilenameScheme names stored files: "timestamp" or "content-hash"
+	FilenameScheme string
+	// PreserveOriginalName prefixes timestamp filenames with a slug of the
+	// uploaded file's name
+	PreserveOriginalName bool
+	// LowercaseExtensions lowercases the uploaded file's extension in stored
+	// filenames, so photo.JPG is stored as ….jpg
+	LowercaseExtensions bool
+	// ShortIDs gives every upload a random base62 ID of ShortIDLength
+	// characters, served as /s/<id>
+	ShortIDs      bool
+	ShortIDLength int
+	// Assets groups the files stored by each upload under an asset ID, with
+	// a manifest at /assets/<id>
+	Assets bool
+
+	// MaxUploadSize caps the request body size
+	MaxUploadSize int
+	// MinFreeDiskSpace refuses new uploads while the filesystem holding the
+	// uploads directory has less free space; zero disables the check
+	MinFreeDiskSpace int
+
+	// MaxConcurrentPerIP caps the uploads and other processing requests one
+	// client IP may have in flight; zero disables the limit
+	MaxConcurrentPerIP int
+	// MaxConcurrentRequests caps the requests in flight across all clients,
+	// health probes excepted; zero disables the limit
+	MaxConcurrentRequests int
+
+	// UploadFieldNames are the multipart fields an upload is read from,
+	// tried in order
+	UploadFieldNames []string
+	// UploadReadRetries is how many more times opening or reading an
+	// uploaded file is tried after a transient failure, waiting
+	// UploadReadRetryDelay before the first retry and twice as long each time after
+	UploadReadRetries    int
+	UploadReadRetryDelay time.Duration
+
+	// MaxFilesPerRequest caps the number of files in one multipart request
+	MaxFilesPerRequest int
+
+	// MaxTrailingBytes is how much data may follow an image's end marker
+	MaxTrailingBytes int
+	// MaxAspectRatio rejects images whose long side exceeds their short side
+	// by more than this factor; zero disables the check
+	MaxAspectRatio float64
+
+	// TrimThreshold is how far a pixel's colour may be from the border
+	// colour and still be trimmed by ?trim=true
+	TrimThreshold float64
+
+	// ProcessingWorkers is how many images may be compressed at once
+	ProcessingWorkers int
+	// VipsConcurrency is the number of threads libvips uses per operation.
+	// libvips reads VIPS_CONCURRENCY itself when it starts; it is parsed
+	// here to validate and report it.
+	VipsConcurrency int
+	// VipsCacheMax and VipsCacheMaxMem bound libvips' operation cache
+	VipsCacheMax    int
+	VipsCacheMaxMem int
+
+	// AutoRotate applies the EXIF orientation when an image is processed
+	AutoRotate bool
+
+	// NormalizeBitDepth converts 16-bit images to 8 bits per channel
+	NormalizeBitDepth bool
+
+	// CopyrightText, when set, replaces all output metadata with this comment
+	CopyrightText string
+
+	// AlphaPolicy is what happens to transparent images: "allow", "flatten"
+	// onto AlphaBackground, or "reject"
+	AlphaPolicy     string
+	AlphaBackground bimg.Color
+
+	// ICCProfilePolicy is what happens to embedded colour profiles:
+	// "keep-all", "keep-srgb" or "strip"
+	ICCProfilePolicy string
+
+	// MaxQualityAttempts caps the re-encodes spent searching for a quality
+	// that meets the size target before dimensions are reduced instead
+	MaxQualityAttempts int
+	// QualitySearch is how that quality is searched for: "binary" or "linear"
+	QualitySearch string
+	// OnSizeExceeded is what happens when no attempt meets the size target:
+	// "reject" or "store-anyway"
+	OnSizeExceeded string
+
+	// ProcessingTimeout bounds the processing of an image and the quality
+	// sweep, each including its wait for a worker
+	ProcessingTimeout time.Duration
+
+	// SlowProcessingThreshold logs images whose queue wait plus processing
+	// time reaches it; zero disables the log
+	SlowProcessingThreshold time.Duration
+	// SlowCompressionThreshold logs the input details of images whose
+	// compression alone takes at least this long; zero disables the log
+	SlowCompressionThreshold time.Duration
+
+	// ResponseCompression compresses JSON and XML responses of at least
+	// ResponseCompressionMinSize bytes with br or gzip
+	ResponseCompression        bool
+	ResponseCompressionMinSize int
+
+	// PrettyJSON indents every JSON response
+	PrettyJSON bool
+	// JSONFieldCase is the style of response field names: "snake" or "camel"
+	JSONFieldCase string
+
+	// PHashBlocklistFile lists perceptual hashes of refused images; uploads
+	// within PHashBlocklistDistance bits of one get 403. The file is checked
+	// for changes every PHashBlocklistPollInterval, zero disabling the check.
+	PHashBlocklistFile         string
+	PHashBlocklistDistance     int
+	PHashBlocklistPollInterval time.Duration
+
+	// APIKeys are the keys loaded from API_KEYS_FILE, by hash; nil leaves the
+	// image-processing endpoints open
+	APIKeys map[string]apiKey
+
+	// TrustedProxies are the peers whose X-Forwarded-For/X-Real-IP headers
+	// are believed when deriving the client IP
+	TrustedProxies []*net.IPNet
+
+	// UploadAllowedOrigins, when set, are the only sites whose pages may post
+	// to /upload, going by the Origin or Referer header
+	UploadAllowedOrigins []string
+
+	// AuditLogFile enables the upload audit log at this path, rotated once it
+	// reaches AuditLogMaxSize with AuditLogBackups old files kept
+	AuditLogFile    string
+	AuditLogMaxSize int
+	AuditLogBackups int
+
+	// EventPublisher is where upload, replace, reprocess and delete events
+	// go: "none" or "nats", at NatsURL on subjects under EventSubjectPrefix.
+	// Up to EventQueueSize events wait to be published.
+	EventPublisher     string
+	NatsURL            string
+	EventSubjectPrefix string
+	EventQueueSize     int
+
+	// Remote fetch limits for /import
+	FetchTimeout  time.Duration
+	FetchMaxBytes int
+	ImportMaxURLs int
+
+	// Limits for /import-zip archives: entry count, total uncompressed
+	// bytes, and how many times its compressed size an entry may inflate to
+	ZipImportMaxEntries int
+	ZipImportMaxBytes   int
+	ZipImportMaxRatio   int
+
+	// ZipMaxFiles caps the filenames in one /images/download-zip request
+	ZipMaxFiles int
+}
+
+// cfg is the configuration in effect, populated by loadConfig in main
+var cfg config
+
+// loadConfig reads the service configuration from environment variables
+func loadConfig() (config, error) {
+	var c config
+	var err error
+
+	if c.CompressionTiers, err = parseCompressionTiers(os.Getenv("COMPRESSION_TIERS")); err != nil {
+		return c, fmt.Errorf("invalid COMPRESSION_TIERS: %v", err)
+	}
+
+	if v := envString("FORCE_OUTPUT_FORMAT", ""); v != "" {
+		if c.ForceOutputFormat, err = parseOutputFormat(v); err != nil {
+			return c, fmt.Errorf("invalid FORCE_OUTPUT_FORMAT: %v", err)
+		}
+		if !bimg.IsTypeSupportedSave(c.ForceOutputFormat) {
+			return c, fmt.Errorf("invalid FORCE_OUTPUT_FORMAT: this libvips build can't write %s", v)
+		}
+	}
+	if c.AllowFormatOverride, err = envBool("ALLOW_FORMAT_OVERRIDE", false); err != nil {
+		return c, err
+	}
+	if c.PassthroughSizes, err = parsePassthroughSizes(os.Getenv("PASSTHROUGH_MAX_SIZES")); err != nil {
+		return c, fmt.Errorf("invalid PASSTHROUGH_MAX_SIZES: %v", err)
+	}
+	if c.FormatMap, err = parseFormatMap(os.Getenv("FORMAT_MAP")); err != nil {
+		return c, fmt.Errorf("invalid FORMAT_MAP: %v", err)
+	}
+
+	if c.ReadTimeout, err = envDuration("READ_TIMEOUT", 3*time.Minute); err != nil {
+		return c, err
+	}
+	if c.WriteTimeout, err = envDuration("WRITE_TIMEOUT", 3*time.Minute); err != nil {
+		return c, err
+	}
+	if c.IdleTimeout, err = envDuration("IDLE_TIMEOUT", 3*time.Minute); err != nil {
+		return c, err
+	}
+	if c.KeepAlive, err = envBool("KEEP_ALIVE", true); err != nil {
+		return c, err
+	}
+	if c.HTTP2, err = envBool("HTTP2_ENABLED", false); err != nil {
+		return c, err
+	}
+	if c.MaxConcurrentStreams, err = envInt("HTTP2_MAX_CONCURRENT_STREAMS", 250); err != nil {
+		return c, err
+	}
+	if c.MaxConcurrentStreams <= 0 {
+		return c, fmt.Errorf("HTTP2_MAX_CONCURRENT_STREAMS must be positive")
+	}
+
+	if c.PublicURL, err = parsePublicURL(os.Getenv("PUBLIC_URL")); err != nil {
+		return c, err
+	}
+	// Without PUBLIC_URL, URLs follow the requests unless told otherwise
+	defaultMode := publicURLFixed
+	if c.PublicURL == "" {
+		defaultMode = publicURLRequestDerived
+	}
+	c.PublicURLMode = envString("PUBLIC_URL_MODE", defaultMode)
+	if c.PublicURLMode != publicURLFixed && c.PublicURLMode != publicURLRequestDerived {
+		return c, fmt.Errorf("invalid PUBLIC_URL_MODE: %q (expected fixed or request-derived)", c.PublicURLMode)
+	}
+	c.RoutePrefix = strings.TrimRight(envString("ROUTE_PREFIX", ""), "/")
+	if c.RoutePrefix != "" && (!strings.HasPrefix(c.RoutePrefix, "/") || strings.ContainsAny(c.RoutePrefix, "?#:* ")) {
+		return c, fmt.Errorf("invalid ROUTE_PREFIX: %q (expected a path such as /images)", c.RoutePrefix)
+	}
+
+	c.TempDir = envString("TEMP_DIR", os.TempDir())
+	if c.TempFileTTL, err = envDuration("TEMP_FILE_TTL", 0); err != nil {
+		return c, err
+	}
+	c.AdminToken = envString("ADMIN_TOKEN", "")
+
+	c.StorageBackend = envString("STORAGE_BACKEND", "disk")
+	if c.StorageBackend != "disk" && c.StorageBackend != "memory" {
+		return c, fmt.Errorf("invalid STORAGE_BACKEND: %q (expected disk or memory)", c.StorageBackend)
+	}
+	c.MetadataDir = envString("METADATA_DIR", "metadata")
+
+	if c.LosslessMaxOversize, err = envInt("LOSSLESS_MAX_OVERSIZE", 50); err != nil {
+		return c, err
+	}
+	if c.LosslessMaxOversize < 0 {
+		return c, fmt.Errorf("LOSSLESS_MAX_OVERSIZE must not be negative")
+	}
+	if c.UploadCreatedStatus, err = envBool("UPLOAD_CREATED_STATUS", false); err != nil {
+		return c, err
+	}
+	if c.DeleteTokens, err = envBool("DELETE_TOKENS", false); err != nil {
+		return c, err
+	}
+	if c.DryRun, err = envBool("DRY_RUN", false); err != nil {
+		return c, err
+	}
+	if c.ServeStatic, err = envBool("SERVE_STATIC", true); err != nil {
+		return c, err
+	}
+	if c.UploadsCaseInsensitive, err = envBool("UPLOADS_CASE_INSENSITIVE", false); err != nil {
+		return c, err
+	}
+	if c.UploadsHeaders, err = parseResponseHeaders(os.Getenv("UPLOADS_HEADERS")); err != nil {
+		return c, fmt.Errorf("invalid UPLOADS_HEADERS: %v", err)
+	}
+	if c.TimingAllowOrigin, err = parseTimingAllowOrigin(os.Getenv("TIMING_ALLOW_ORIGIN")); err != nil {
+		return c, err
+	}
+	if c.TimingAllowOrigin != "" {
+		c.UploadsHeaders = append(c.UploadsHeaders, responseHeader{"Timing-Allow-Origin", c.TimingAllowOrigin})
+	}
+	if c.ServerTiming, err = envBool("SERVER_TIMING", false); err != nil {
+		return c, err
+	}
+	if c.VersionedURLs, err = envBool("VERSIONED_URLS", false); err != nil {
+		return c, err
+	}
+
+	c.MissingImagePlaceholder = envString("MISSING_IMAGE_PLACEHOLDER", "")
+	if c.MissingImageStatus, err = envInt("MISSING_IMAGE_STATUS", 200); err != nil {
+		return c, err
+	}
+	if c.MissingImageStatus < 200 || c.MissingImageStatus > 599 {
+		return c, fmt.Errorf("MISSING_IMAGE_STATUS must be an HTTP status between 200 and 599")
+	}
+
+	if c.UploadTTL, err = envDuration("UPLOAD_TTL", 0); err != nil {
+		return c, err
+	}
+	c.LogFormat = envString("LOG_FORMAT", "text")
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return c, fmt.Errorf("invalid LOG_FORMAT: %q (expected text or json)", c.LogFormat)
+	}
+	if c.LogLevel, err = parseLogLevel(envString("LOG_LEVEL", "info")); err != nil {
+		return c, err
+	}
+	if c.CleanupInterval, err = envDuration("CLEANUP_INTERVAL", 10*time.Minute); err != nil {
+		return c, err
+	}
+	if c.CleanupInterval == 0 {
+		return c, fmt.Errorf("CLEANUP_INTERVAL must be positive")
+	}
+	if c.ScrubInterval, err = envDuration("SCRUB_INTERVAL", 0); err != nil {
+		return c, err
+	}
+	if c.MaxExpiresIn, err = envDuration("MAX_EXPIRES_IN", 30*24*time.Hour); err != nil {
+		return c, err
+	}
+	if c.MaxExpiresIn == 0 {
+		return c, fmt.Errorf("MAX_EXPIRES_IN must be positive")
+	}
+	if c.PendingUploads, err = envBool("PENDING_UPLOADS", false); err != nil {
+		return c, err
+	}
+	if c.PendingGracePeriod, err = envDuration("PENDING_GRACE_PERIOD", time.Hour); err != nil {
+		return c, err
+	}
+	if c.PendingGracePeriod == 0 {
+		return c, fmt.Errorf("PENDING_GRACE_PERIOD must be positive")
+	}
+
+	c.FilenameScheme = envString("FILENAME_SCHEME", "timestamp")
+	if c.FilenameScheme != "timestamp" && c.FilenameScheme != "content-hash" {
+		return c, fmt.Errorf("invalid FILENAME_SCHEME: %q (expected timestamp or content-hash)", c.FilenameScheme)
+	}
+	// Stored names are flat, so there are no date directories to partition
+	// into; refuse the setting rather than ignore it
+	if v := envString("PARTITION_BY", "none"); v != "none" {
+		return c, fmt.Errorf("unsupported PARTITION_BY: %q (uploads are stored flat; only none is supported)", v)
+	}
+	if c.ShortIDs, err = envBool("SHORT_IDS", false); err != nil {
+		return c, err
+	}
+	if c.ShortIDLength, err = envInt("SHORT_ID_LENGTH", 8); err != nil {
+		return c, err
+	}
+	if c.ShortIDLength < 4 || c.ShortIDLength > 32 {
+		return c, fmt.Errorf("SHORT_ID_LENGTH must be between 4 and 32")
+	}
+	if c.Assets, err = envBool("ASSETS", false); err != nil {
+		return c, err
+	}
+	if c.PreserveOriginalName, err = envBool("PRESERVE_ORIGINAL_NAME", false); err != nil {
+		return c, err
+	}
+	if c.LowercaseExtensions, err = envBool("LOWERCASE_EXTENSIONS", true); err != nil {
+		return c, err
+	}
+
+	if c.MaxUploadSize, err = envByteSize("MAX_UPLOAD_SIZE", 20*1024*1024); err != nil {
+		return c, err
+	}
+	if c.MaxUploadSize == 0 {
+		return c, fmt.Errorf("MAX_UPLOAD_SIZE must be positive")
+	}
+	if c.MinFreeDiskSpace, err = envByteSize("MIN_FREE_DISK_SPACE", 0); err != nil {
+		return c, err
+	}
+	if c.MaxConcurrentPerIP, err = envInt("MAX_CONCURRENT_PER_IP", 0); err != nil {
+		return c, err
+	}
+	if c.MaxConcurrentPerIP < 0 {
+		return c, fmt.Errorf("MAX_CONCURRENT_PER_IP must not be negative")
+	}
+	if c.MaxConcurrentRequests, err = envInt("MAX_CONCURRENT_REQUESTS", 0); err != nil {
+		return c, err
+	}
+	if c.MaxConcurrentRequests < 0 {
+		return c, fmt.Errorf("MAX_CONCURRENT_REQUESTS must not be negative")
+	}
+	for _, name := range strings.Split(envString("UPLOAD_FIELD_NAMES", "image"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			c.UploadFieldNames = append(c.UploadFieldNames, name)
+		}
+	}
+	if len(c.UploadFieldNames) == 0 {
+		return c, fmt.Errorf("UPLOAD_FIELD_NAMES must name at least one field")
+	}
+	if c.UploadReadRetries, err = envInt("UPLOAD_READ_RETRIES", 2); err != nil {
+		return c, err
+	}
+	if c.UploadReadRetries < 0 {
+		return c, fmt.Errorf("UPLOAD_READ_RETRIES must not be negative")
+	}
+	if c.UploadReadRetryDelay, err = envDuration("UPLOAD_READ_RETRY_DELAY", 50*time.Millisecond); err != nil {
+		return c, err
+	}
+	if c.MaxFilesPerRequest, err = envInt("MAX_FILES_PER_REQUEST", 20); err != nil {
+		return c, err
+	}
+	if c.MaxFilesPerRequest <= 0 {
+		return c, fmt.Errorf("MAX_FILES_PER_REQUEST must be positive")
+	}
+
+	if c.MaxTrailingBytes, err = envByteSize("MAX_TRAILING_BYTES", 1024); err != nil {
+		return c, err
+	}
+	if c.MaxAspectRatio, err = parseAspectRatio(envString("MAX_ASPECT_RATIO", "20")); err != nil {
+		return c, err
+	}
+	v := envString("TRIM_THRESHOLD", "10")
+	if c.TrimThreshold, err = strconv.ParseFloat(v, 64); err != nil || !(c.TrimThreshold >= 0 && c.TrimThreshold <= 255) {
+		return c, fmt.Errorf("invalid TRIM_THRESHOLD: %q (expected a number between 0 and 255)", v)
+	}
+
+	if c.ProcessingWorkers, err = envInt("PROCESSING_WORKERS", runtime.NumCPU()); err != nil {
+		return c, err
+	}
+	if c.ProcessingWorkers <= 0 {
+		return c, fmt.Errorf("PROCESSING_WORKERS must be positive")
+	}
+	// bimg sets libvips to one thread per operation unless VIPS_CONCURRENCY is set
+	if c.VipsConcurrency, err = envInt("VIPS_CONCURRENCY", 1); err != nil {
+		return c, err
+	}
+	if c.VipsConcurrency <= 0 {
+		return c, fmt.Errorf("VIPS_CONCURRENCY must be positive")
+	}
+	if c.VipsCacheMax, err = envInt("VIPS_CACHE_MAX", 500); err != nil {
+		return c, err
+	}
+	if c.VipsCacheMax < 0 {
+		return c, fmt.Errorf("VIPS_CACHE_MAX must not be negative")
+	}
+	if c.VipsCacheMaxMem, err = envByteSize("VIPS_CACHE_MAX_MEM", 100*1024*1024); err != nil {
+		return c, err
+	}
+
+	if c.AutoRotate, err = envBool("AUTO_ROTATE", true); err != nil {
+		return c, err
+	}
+	if c.NormalizeBitDepth, err = envBool("NORMALIZE_BIT_DEPTH", false); err != nil {
+		return c, err
+	}
+	c.CopyrightText = envString("COPYRIGHT_TEXT", "")
+
+	c.AlphaPolicy = envString("ALPHA_POLICY", alphaAllow)
+	if c.AlphaPolicy != alphaAllow && c.AlphaPolicy != alphaFlatten && c.AlphaPolicy != alphaReject {
+		return c, fmt.Errorf("invalid ALPHA_POLICY: %q (expected allow, flatten or reject)", c.AlphaPolicy)
+	}
+	if c.AlphaBackground, err = parseHexColor(envString("ALPHA_BACKGROUND", "ffffff")); err != nil {
+		return c, fmt.Errorf("invalid ALPHA_BACKGROUND: %v", err)
+	}
+	c.ICCProfilePolicy = envString("ICC_PROFILE_POLICY", iccKeepAll)
+	if c.ICCProfilePolicy != iccKeepAll && c.ICCProfilePolicy != iccKeepSRGB && c.ICCProfilePolicy != iccStrip {
+		return c, fmt.Errorf("invalid ICC_PROFILE_POLICY: %q (expected keep-all, keep-srgb or strip)", c.ICCProfilePolicy)
+	}
+
+	if c.MaxQualityAttempts, err = envInt("MAX_QUALITY_ATTEMPTS", 8); err != nil {
+		return c, err
+	}
+	if c.MaxQualityAttempts < 0 {
+		return c, fmt.Errorf("MAX_QUALITY_ATTEMPTS must not be negative")
+	}
+	c.QualitySearch = envString("QUALITY_SEARCH", qualitySearchBinary)
+	if c.QualitySearch != qualitySearchBinary && c.QualitySearch != qualitySearchLinear {
+		return c, fmt.Errorf("invalid QUALITY_SEARCH: %q (expected binary or linear)", c.QualitySearch)
+	}
+	c.OnSizeExceeded = envString("ON_SIZE_EXCEEDED", sizeExceededReject)
+	if c.OnSizeExceeded != sizeExceededReject && c.OnSizeExceeded != sizeExceededStoreAnyway {
+		return c, fmt.Errorf("invalid ON_SIZE_EXCEEDED: %q (expected reject or store-anyway)", c.OnSizeExceeded)
+	}
+	if c.ProcessingTimeout, err = envDuration("PROCESSING_TIMEOUT", 30*time.Second); err != nil {
+		return c, err
+	}
+	if c.ProcessingTimeout == 0 {
+		return c, fmt.Errorf("PROCESSING_TIMEOUT must be positive")
+	}
+	if c.SlowProcessingThreshold, err = envDuration("SLOW_PROCESSING_THRESHOLD", 5*time.Second); err != nil {
+		return c, err
+	}
+	if c.SlowCompressionThreshold, err = envDuration("SLOW_COMPRESSION_THRESHOLD", 0); err != nil {
+		return c, err
+	}
+
+	if c.ResponseCompression, err = envBool("RESPONSE_COMPRESSION", false); err != nil {
+		return c, err
+	}
+	if c.ResponseCompressionMinSize, err = envByteSize("RESPONSE_COMPRESSION_MIN_SIZE", 1024); err != nil {
+		return c, err
+	}
+
+	if c.PrettyJSON, err = envBool("PRETTY_JSON", false); err != nil {
+		return c, err
+	}
+	c.JSONFieldCase = envString("JSON_FIELD_CASE", "snake")
+	if c.JSONFieldCase != "snake" && c.JSONFieldCase != "camel" {
+		return c, fmt.Errorf("invalid JSON_FIELD_CASE: %q (expected snake or camel)", c.JSONFieldCase)
+	}
+
+	c.PHashBlocklistFile = envString("PHASH_BLOCKLIST_FILE", "")
+	if c.PHashBlocklistDistance, err = envInt("PHASH_BLOCKLIST_DISTANCE", 6); err != nil {
+		return c, err
+	}
+	if c.PHashBlocklistDistance < 0 || c.PHashBlocklistDistance > 64 {
+		return c, fmt.Errorf("PHASH_BLOCKLIST_DISTANCE must be between 0 and 64")
+	}
+	if c.PHashBlocklistPollInterval, err = envDuration("PHASH_BLOCKLIST_POLL_INTERVAL", 30*time.Second); err != nil {
+		return c, err
+	}
+
+	if path := envString("API_KEYS_FILE", ""); path != "" {
+		if c.APIKeys, err = loadAPIKeys(path); err != nil {
+			return c, fmt.Errorf("invalid API_KEYS_FILE: %v", err)
+		}
+	}
+
+	if c.TrustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		return c, fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
+	if c.UploadAllowedOrigins, err = parseAllowedOrigins(os.Getenv("UPLOAD_ALLOWED_ORIGINS")); err != nil {
+		return c, fmt.Errorf("invalid UPLOAD_ALLOWED_ORIGINS: %v", err)
+	}
+
+	c.AuditLogFile = envString("AUDIT_LOG_FILE", "")
+	if c.AuditLogMaxSize, err = envByteSize("AUDIT_LOG_MAX_SIZE", 100*1024*1024); err != nil {
+		return c, err
+	}
+	if c.AuditLogMaxSize == 0 {
+		return c, fmt.Errorf("AUDIT_LOG_MAX_SIZE must be positive")
+	}
+	if c.AuditLogBackups, err = envInt("AUDIT_LOG_BACKUPS", 5); err != nil {
+		return c, err
+	}
+	if c.AuditLogBackups < 0 {
+		return c, fmt.Errorf("AUDIT_LOG_BACKUPS must not be negative")
+	}
+
+	c.EventPublisher = envString("EVENT_PUBLISHER", eventPublisherNone)
+	if c.EventPublisher != eventPublisherNone && c.EventPublisher != eventPublisherNATS {
+		return c, fmt.Errorf("invalid EVENT_PUBLISHER: %q (expected none or nats)", c.EventPublisher)
+	}
+	c.NatsURL = envString("NATS_URL", "nats://localhost:4222")
+	c.EventSubjectPrefix = envString("EVENT_SUBJECT_PREFIX", "images")
+	if c.EventSubjectPrefix == "" || strings.ContainsAny(c.EventSubjectPrefix, " \t\r\n*>") {
+		return c, fmt.Errorf("invalid EVENT_SUBJECT_PREFIX: %q (expected a NATS subject such as images)", c.EventSubjectPrefix)
+	}
+	if c.EventQueueSize, err = envInt("EVENT_QUEUE_SIZE", 1000); err != nil {
+		return c, err
+	}
+	if c.EventQueueSize <= 0 {
+		return c, fmt.Errorf("EVENT_QUEUE_SIZE must be positive")
+	}
+
+	if c.FetchTimeout, err = envDuration("FETCH_TIMEOUT", 15*time.Second); err != nil {
+		return c, err
+	}
+	if c.FetchTimeout == 0 {
+		return c, fmt.Errorf("FETCH_TIMEOUT must be positive")
+	}
+	if c.FetchMaxBytes, err = envByteSize("FETCH_MAX_BYTES", 20*1024*1024); err != nil {
+		return c, err
+	}
+	if c.FetchMaxBytes == 0 {
+		return c, fmt.Errorf("FETCH_MAX_BYTES must be positive")
+	}
+	if c.ImportMaxURLs, err = envInt("IMPORT_MAX_URLS", 50); err != nil {
+		return c, err
+	}
+	if c.ImportMaxURLs <= 0 {
+		return c, fmt.Errorf("IMPORT_MAX_URLS must be positive")
+	}
+	if c.ZipImportMaxEntries, err = envInt("ZIP_IMPORT_MAX_ENTRIES", 1000); err != nil {
+		return c, err
+	}
+	if c.ZipImportMaxEntries <= 0 {
+		return c, fmt.Errorf("ZIP_IMPORT_MAX_ENTRIES must be positive")
+	}
+	if c.ZipImportMaxBytes, err = envByteSize("ZIP_IMPORT_MAX_BYTES", 1024*1024*1024); err != nil {
+		return c, err
+	}
+	if c.ZipImportMaxBytes == 0 {
+		return c, fmt.Errorf("ZIP_IMPORT_MAX_BYTES must be positive")
+	}
+	if c.ZipImportMaxRatio, err = envInt("ZIP_IMPORT_MAX_RATIO", 100); err != nil {
+		return c, err
+	}
+	if c.ZipImportMaxRatio <= 0 {
+		return c, fmt.Errorf("ZIP_IMPORT_MAX_RATIO must be positive")
+	}
+
+	if c.ZipMaxFiles, err = envInt("ZIP_MAX_FILES", 500); err != nil {
+		return c, err
+	}
+	if c.ZipMaxFiles <= 0 {
+		return c, fmt.Errorf("ZIP_MAX_FILES must be positive")
+	}
+
+	return c, nil
+}
+
+// parseByteSize parses a size such as "512", "256KB" or "1MB" into bytes.
+// Units are binary (1KB = 1024 bytes).
+func parseByteSize(s string) (int, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(s, "GB"):
+		multiplier, s = 1024*1024*1024, strings.TrimSuffix(s, "GB")
+	case strings.HasSuffix(s, "MB"):
+		multiplier, s = 1024*1024, strings.TrimSuffix(s, "MB")
+	case strings.HasSuffix(s, "KB"):
+		multiplier, s = 1024, strings.TrimSuffix(s, "KB")
+	case strings.HasSuffix(s, "B"):
+		s = strings.TrimSuffix(s, "B")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	// Sizes also come from clients (?max_bytes=), so one too large in bytes
+	// is refused rather than wrapped around
+	if err != nil || n < 0 || n > math.MaxInt/multiplier {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// parseAspectRatio parses MAX_ASPECT_RATIO, given as "20" or "20:1".
+// Zero disables the limit; otherwise it must be at least 1.
+func parseAspectRatio(s string) (float64, error) {
+	ratio, err := strconv.ParseFloat(strings.TrimSuffix(s, ":1"), 64)
+	if err != nil || math.IsNaN(ratio) || math.IsInf(ratio, 0) {
+		return 0, fmt.Errorf("invalid MAX_ASPECT_RATIO: %q (expected a ratio such as 20 or 20:1)", s)
+	}
+	if ratio != 0 && ratio < 1 {
+		return 0, fmt.Errorf("MAX_ASPECT_RATIO must be 0 or at least 1")
+	}
+	return ratio, nil
+}
+
+// envString reads a string from the environment, falling back to def when unset
+func envString(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+// envByteSize reads a byte size such as "512KB" or "20MB" from the environment
+func envByteSize(name string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	n, err := parseByteSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return n, nil
+}
+
+// envDuration reads a non-negative duration such as "30s" or "2m" from the environment
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s: %q is not a non-negative duration", name, v)
+	}
+	return d, nil
+}
+
+// envBool reads a boolean such as "true" or "0" from the environment
+func envBool(name string, def bool) (bool, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %q is not a boolean", name, v)
+	}
+	return b, nil
+}
+
+// envInt reads an integer from the environment
+func envInt(name string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q is not an integer", name, v)
+	}
+	return n, nil
+}
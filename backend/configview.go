@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// redacted replaces secret settings in the configuration report
+const redacted = "[redacted]"
+
+// isSecretSetting reports whether a config field holds a credential and is
+// left out of the configuration report. String fields named like a token,
+// secret or password are secret by default, so new ones aren't leaked by
+// accident; switches such as DeleteTokens are reported.
+func isSecretSetting(field reflect.StructField) bool {
+	if field.Type.Kind() != reflect.String {
+		return false
+	}
+	for _, word := range []string{"Token", "Secret", "Password"} {
+		if strings.Contains(field.Name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGetConfig returns the configuration in effect, one snake_case key per
+// config field, with secrets redacted and API keys reduced to their names
+func handleGetConfig(ctx context.Context, c *app.RequestContext) {
+	respond(c, consts.StatusOK, configReport(cfg))
+}
+
+// configReport describes c field by field, so settings added later are
+// reported without changes here
+func configReport(c config) map[string]interface{} {
+	v := reflect.ValueOf(c)
+	t := v.Type()
+	report := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		value := configValue(v.Field(i).Interface())
+		if isSecretSetting(t.Field(i)) && !v.Field(i).IsZero() {
+			value = redacted
+		}
+		report[snakeCase(name)] = value
+	}
+	// Broker URLs may carry credentials
+	report["nats_url"] = redactURLUser(c.NatsURL)
+	return report
+}
+
+// configValue renders a config value in the form its environment variable
+// takes where the JSON encoding would be unreadable, e.g. durations as "10m0s"
+// rather than nanoseconds
+func configValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.String()
+	case bimg.ImageType:
+		if v == bimg.UNKNOWN {
+			return nil
+		}
+		return bimg.ImageTypeName(v)
+	case map[string]bimg.ImageType:
+		formats := make(map[string]string, len(v))
+		for input, output := range v {
+			formats[input] = bimg.ImageTypeName(output)
+		}
+		return formats
+	case bimg.Color:
+		return fmt.Sprintf("%02x%02x%02x", v.R, v.G, v.B)
+	case hlog.Level:
+		for name, level := range logLevels {
+			if level == v {
+				return name
+			}
+		}
+	case []compressionTier:
+		tiers := make([]string, len(v))
+		for i, tier := range v {
+			limit := fmt.Sprintf("%dB", tier.limit)
+			if tier.byPixels {
+				limit = fmt.Sprintf("%dpx", tier.limit)
+			}
+			tiers[i] = fmt.Sprintf("%s:%dB", limit, tier.target)
+		}
+		return tiers
+	case []responseHeader:
+		headers := make(map[string]string, len(v))
+		for _, h := range v {
+			headers[h.name] = h.value
+		}
+		return headers
+	case []*net.IPNet:
+		networks := make([]string, len(v))
+		for i, n := range v {
+			networks[i] = n.String()
+		}
+		return networks
+	case map[string]apiKey:
+		// The keys themselves are credentials; only their owners are shown
+		names := make([]string, 0, len(v))
+		for _, key := range v {
+			names = append(names, key.Name)
+		}
+		sort.Strings(names)
+		return map[string]interface{}{"count": len(v), "names": names}
+	}
+	return value
+}
+
+// snakeCase converts a Go field name such as MaxConcurrentPerIP to
+// max_concurrent_per_ip. Runs of capitals are one word, with a plural s:
+// JSONFieldCase becomes json_field_case and ImportMaxURLs import_max_urls.
+func snakeCase(name string) string {
+	// PHash is spelt phash in the environment variables
+	runes := []rune(strings.Replace(name, "PHash", "Phash", 1))
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			plural := i+1 < len(runes) && runes[i+1] == 's' && (i+2 == len(runes) || unicode.IsUpper(runes[i+2]))
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower && !plural) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// redactURLUser replaces the user information of a URL, which may be a
+// password or token
+func redactURLUser(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return strings.Replace(s, u.User.String()+"@", redacted+"@", 1)
+}
@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestConfigReportRedactsSecrets(t *testing.T) {
+	setupTestServer(t, map[string]string{"DELETE_TOKENS": "true", "ADMIN_TOKEN": "admin-secret"})
+	report := configReport(cfg)
+	if report["delete_tokens"] != true {
+		t.Errorf("delete_tokens = %v, want true", report["delete_tokens"])
+	}
+	if report["admin_token"] != redacted {
+		t.Errorf("admin_token = %v, want %q", report["admin_token"], redacted)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// embedCopyright writes text into the image's comment metadata: a COM
+// segment for JPEG, a "Copyright" text chunk for PNG and a comment extension
+// for GIF. libvips can't add metadata fields, so the container is edited
+// directly. Other formats, and data that doesn't parse, are returned as is.
+func embedCopyright(data []byte, text string) []byte {
+	if text == "" {
+		return data
+	}
+	switch sniffFormat(data) {
+	case "jpeg":
+		return jpegWithComment(data, text)
+	case "png":
+		return pngWithText(data, "Copyright", text)
+	case "gif":
+		return gifWithComment(data, text)
+	}
+	return data
+}
+
+// jpegWithComment inserts a COM segment after SOI and any APP0 (JFIF) segment,
+// which must stay first for strict readers
+func jpegWithComment(data []byte, text string) []byte {
+	comment := []byte(text)
+	if len(comment) > 0xFFFF-2 {
+		comment = comment[:0xFFFF-2]
+	}
+	at := 2
+	if len(data) >= 6 && data[2] == 0xFF && data[3] == 0xE0 {
+		at = 4 + int(binary.BigEndian.Uint16(data[4:]))
+		if at > len(data) {
+			return data
+		}
+	}
+
+	segment := make([]byte, 4, 4+len(comment))
+	segment[0], segment[1] = 0xFF, 0xFE
+	binary.BigEndian.PutUint16(segment[2:], uint16(2+len(comment)))
+	segment = append(segment, comment...)
+	return splice(data, at, segment)
+}
+
+// pngWithText inserts a text chunk after IHDR. tEXt only holds Latin-1,
+// so non-ASCII text goes in an uncompressed iTXt chunk instead.
+func pngWithText(data []byte, keyword, text string) []byte {
+	const ihdrEnd = 8 + 12 + 13 // signature, then IHDR's length, type, data and CRC
+	if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return data
+	}
+
+	chunkType, body := "tEXt", []byte(keyword+"\x00"+text)
+	for i := 0; i < len(text); i++ {
+		if text[i] >= 0x80 {
+			// keyword, compression flag and method, empty language and translated keyword
+			chunkType, body = "iTXt", []byte(keyword+"\x00\x00\x00\x00\x00"+text)
+			break
+		}
+	}
+
+	chunk := make([]byte, 8, 12+len(body))
+	binary.BigEndian.PutUint32(chunk, uint32(len(body)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, body...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc[:]...)
+	return splice(data, ihdrEnd, chunk)
+}
+
+// gifWithComment inserts a comment extension before the trailer
+func gifWithComment(data []byte, text string) []byte {
+	end, err := gifDataEnd(data)
+	if err != nil {
+		return data
+	}
+
+	var ext bytes.Buffer
+	ext.Write([]byte{0x21, 0xFE})
+	for comment := []byte(text); len(comment) > 0; {
+		n := len(comment)
+		if n > 255 {
+			n = 255
+		}
+		ext.WriteByte(byte(n))
+		ext.Write(comment[:n])
+		comment = comment[n:]
+	}
+	ext.WriteByte(0)
+	return splice(data, end-1, ext.Bytes())
+}
+
+// splice returns a copy of data with insert placed at offset at
+func splice(data []byte, at int, insert []byte) []byte {
+	out := make([]byte, 0, len(data)+len(insert))
+	out = append(out, data[:at]...)
+	out = append(out, insert...)
+	return append(out, data[at:]...)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// newToken returns a random secret token, such as a deletion token, and the
+// hash kept in the file's metadata. Only the hash is stored, so the token
+// can't be recovered from the metadata directory.
+func newToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the stored form of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validToken reports whether token matches the stored hash, comparing in
+// constant time
+func validToken(token, hash string) bool {
+	if token == "" || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(hash)) == 1
+}
+
+// mayModifyStoredFile reports whether the request may delete or change the
+// stored file record describes: it carries the file's deletion token as
+// ?token= or the admin token as an Authorization bearer token
+func mayModifyStoredFile(c *app.RequestContext, record fileMeta) bool {
+	return validToken(c.Query("token"), record.DeleteTokenHash) || hasAdminToken(c)
+}
+
+// handleDeleteUpload deletes a stored file when ?token= matches the deletion
+// token returned at upload, or for the admin token
+func handleDeleteUpload(ctx context.Context, c *app.RequestContext) {
+	filename := c.Param("filename")
+	if !validStoredName(filename) {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid filename",
+		})
+		return
+	}
+	unlock := filenameLocks.Lock(filename)
+	defer unlock()
+
+	exists, err := store.Exists(filename)
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to look up file",
+		})
+		return
+	}
+	if !exists {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "File not found",
+		})
+		return
+	}
+	record, _, err := meta.Get(filename)
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to read upload metadata",
+		})
+		return
+	}
+	if !mayModifyStoredFile(c, record) {
+		respond(c, consts.StatusForbidden, map[string]interface{}{
+			"error": "Deleting a file needs its deletion token or the admin token",
+		})
+		return
+	}
+
+	if err := store.Delete(filename); err != nil && err != errNotFound {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to delete file",
+		})
+		return
+	}
+	deleted := deletedEvent(filename, "requested", record)
+	if err := meta.Delete(filename); err != nil {
+		hlog.CtxWarnf(ctx, "failed to delete metadata for %s: %v", filename, err)
+	}
+	if err := phashes.Remove(filename); err != nil {
+		hlog.CtxWarnf(ctx, "failed to update phash index for %s: %v", filename, err)
+	}
+	if err := shortIDs.Remove(filename); err != nil {
+		hlog.CtxWarnf(ctx, "failed to update short ID index for %s: %v", filename, err)
+	}
+	if err := assets.Remove(filename); err != nil {
+		hlog.CtxWarnf(ctx, "failed to update asset index for %s: %v", filename, err)
+	}
+	events.Emit(deleted)
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"deleted": filename,
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+func TestDeleteUpload(t *testing.T) {
+	setupTestServer(t, map[string]string{"DELETE_TOKENS": "true", "ADMIN_TOKEN": "admin-secret"})
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+	engine.DELETE("/uploads/:filename", handleDeleteUpload)
+	admin := ut.Header{Key: "Authorization", Value: "Bearer admin-secret"}
+
+	tests := []struct {
+		name      string
+		withToken bool // whether the upload gets a deletion token
+		query     string
+		headers   []ut.Header
+		status    int
+	}{
+		{"no token", true, "", nil, consts.StatusForbidden},
+		{"wrong token", true, "?token=wrong", nil, consts.StatusForbidden},
+		{"wrong admin token", true, "", []ut.Header{{Key: "Authorization", Value: "Bearer wrong"}}, consts.StatusForbidden},
+		{"deletion token", true, "?token={token}", nil, consts.StatusOK},
+		{"admin token", true, "", []ut.Header{admin}, consts.StatusOK},
+		{"admin token without deletion token", false, "", []ut.Header{admin}, consts.StatusOK},
+		{"no deletion token", false, "?token=", nil, consts.StatusForbidden},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.DeleteTokens = tt.withToken
+			w := postImage(engine, "/upload", "photo.png", testPNG(t, 40+i, 40, 255))
+			if w.Code != consts.StatusOK {
+				t.Fatalf("upload status = %d: %s", w.Code, w.Body.String())
+			}
+			uploaded := decodeJSON(t, w)
+			filename, _ := uploaded["filename"].(string)
+			token, _ := uploaded["delete_token"].(string)
+			query := strings.ReplaceAll(tt.query, "{token}", token)
+
+			w = ut.PerformRequest(engine, "DELETE", "/uploads/"+filename+query, nil, tt.headers...)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if exists, _ := store.Exists(filename); exists == (tt.status == consts.StatusOK) {
+				t.Errorf("file exists = %v after status %d", exists, w.Code)
+			}
+		})
+	}
+
+	t.Run("invalid filename", func(t *testing.T) {
+		w := ut.PerformRequest(engine, "DELETE", "/uploads/.hidden", nil, admin)
+		if w.Code != consts.StatusBadRequest {
+			t.Errorf("status = %d, want 400: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// handleDetect identifies an image sent as a multipart upload or as the raw
+// request body: its format, dimensions, colorspace and animation, and
+// whether /upload would accept it. Nothing is compressed or stored; the
+// image is only decoded to a small thumbnail to check it isn't corrupt, so
+// it doesn't wait for a processing worker.
+// Unlike /images/:filename/analyze it works on bytes the client holds.
+func handleDetect(ctx context.Context, c *app.RequestContext) {
+	data, err := readDetectInput(ctx, c)
+	if err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result := map[string]interface{}{
+		"format":    "unknown",
+		"mime_type": imageContentType(data),
+		"size":      len(data),
+	}
+	if format := bimg.DetermineImageType(data); format != bimg.UNKNOWN {
+		result["format"] = bimg.ImageTypeName(format)
+	}
+	if dims, err := bimg.Size(data); err == nil {
+		result["width"] = dims.Width
+		result["height"] = dims.Height
+	}
+	if info, err := imageColorInfo(data); err == nil {
+		result["colorspace"] = info.Colorspace
+		result["channels"] = info.Channels
+		result["has_alpha"] = info.HasAlpha
+	}
+	frames := frameCount(data)
+	result["animated"] = frames > 1
+	if frames > 0 {
+		result["frames"] = frames
+	}
+
+	// The checks an upload goes through, all of them reported rather than
+	// the first; oversized requests were already refused
+	var problems validationErrors
+	if err := validateImageData(data); err != nil {
+		problems.add(err)
+	} else {
+		problems.add(checkAspectRatio(data))
+		problems.add(checkAlphaPolicy(data))
+		if format := bimg.DetermineImageType(data); format != bimg.UNKNOWN && !canLoad(format) {
+			problems.add(unsupportedFormat(format))
+		}
+	}
+	messages := make([]string, len(problems))
+	for i, p := range problems {
+		messages[i] = p.message
+	}
+	result["within_limits"] = len(problems) == 0
+	result["problems"] = messages
+
+	respond(c, consts.StatusOK, result)
+}
+
+// readDetectInput returns the image sent to /detect: the file of a multipart
+// upload, read as /upload reads it, or else the whole request body
+func readDetectInput(ctx context.Context, c *app.RequestContext) ([]byte, error) {
+	if bytes.HasPrefix(c.Request.Header.ContentType(), []byte("multipart/form-data")) {
+		_, data, err := readUploadedImage(ctx, c)
+		return data, err
+	}
+	if err := checkContentLength(c); err != nil {
+		return nil, err
+	}
+	data := c.Request.Body()
+	if len(data) == 0 {
+		return nil, &httpError{consts.StatusBadRequest, fmt.Sprintf("Failed to get image from request: expected a raw image body or a file in form field %s", cfg.UploadFieldNames[0])}
+	}
+	if limit := uploadSizeLimit(c); len(data) > limit {
+		return nil, &httpError{consts.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the %d byte limit", limit)}
+	}
+	return data, nil
+}
+
+// frameCount returns how many frames an image has: those of a GIF, an
+// animated PNG's acTL count or an animated WebP's ANMF chunks, 1 for other
+// images, and 0 when it can't tell
+func frameCount(data []byte) int {
+	switch sniffFormat(data) {
+	case "gif":
+		return gifFrameCount(data)
+	case "png":
+		return pngFrameCount(data)
+	case "webp":
+		return webpFrameCount(data)
+	case "":
+		return 0
+	}
+	return 1
+}
+
+// pngFrameCount reads the frame count of an animated PNG from the acTL
+// chunk that precedes the image data
+func pngFrameCount(data []byte) int {
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		switch string(data[i+4 : i+8]) {
+		case "acTL":
+			if length >= 8 && i+12 <= len(data) {
+				return int(binary.BigEndian.Uint32(data[i+8:]))
+			}
+			return 0
+		case "IDAT", "IEND":
+			return 1
+		}
+		i += 12 + length
+	}
+	return 0
+}
+
+// webpFrameCount counts the ANMF chunks of an animated WebP
+func webpFrameCount(data []byte) int {
+	frames := 0
+	for i := 12; i+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if string(data[i:i+4]) == "ANMF" {
+			frames++
+		}
+		i += 8 + length + length&1 // chunks are padded to an even size
+	}
+	if frames == 0 {
+		return 1
+	}
+	return frames
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// errDiskUsageUnsupported is returned by statDisk on platforms without statfs
+var errDiskUsageUnsupported = errors.New("disk usage is not available on this platform")
+
+// diskUsage is the space on a filesystem, in bytes. Free is what an
+// unprivileged process may still use.
+type diskUsage struct {
+	Free  uint64
+	Total uint64
+}
+
+// uploadsVolume is the directory whose filesystem is reported and checked,
+// set in main to the uploads directory for disk storage
+var uploadsVolume string
+
+// uploadsDiskUsage returns the space on the filesystem holding the uploads
+// directory, reporting false when there is nothing to report: uploads aren't
+// stored on disk, or the platform can't tell
+func uploadsDiskUsage() (diskUsage, bool, error) {
+	if uploadsVolume == "" {
+		return diskUsage{}, false, nil
+	}
+	usage, err := statDisk(existingDir(uploadsVolume))
+	if err == errDiskUsageUnsupported {
+		return diskUsage{}, false, nil
+	}
+	if err != nil {
+		return diskUsage{}, false, err
+	}
+	return usage, true, nil
+}
+
+// existingDir returns dir or its nearest existing parent, as the uploads
+// directory is only created by the first upload
+func existingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// checkFreeDiskSpace reports an error when the uploads filesystem has less
+// than MIN_FREE_DISK_SPACE free. Usage that can't be read doesn't fail it.
+func checkFreeDiskSpace(ctx context.Context) error {
+	if cfg.MinFreeDiskSpace == 0 {
+		return nil
+	}
+	usage, ok, err := uploadsDiskUsage()
+	if err != nil {
+		hlog.CtxWarnf(ctx, "failed to read free disk space: %v", err)
+		return nil
+	}
+	if ok && usage.Free < uint64(cfg.MinFreeDiskSpace) {
+		return fmt.Errorf("only %d bytes free, below MIN_FREE_DISK_SPACE", usage.Free)
+	}
+	return nil
+}
+
+// requireFreeDiskSpace refuses requests that store new files with 507 while
+// the uploads filesystem is short of space
+func requireFreeDiskSpace(ctx context.Context, c *app.RequestContext) {
+	if err := checkFreeDiskSpace(ctx); err != nil {
+		hlog.CtxWarnf(ctx, "refusing upload: %v", err)
+		respond(c, consts.StatusInsufficientStorage, map[string]interface{}{
+			"error": "Not enough disk space to store uploads",
+		})
+		c.Abort()
+		return
+	}
+	c.Next(ctx)
+}
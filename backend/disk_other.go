@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// statDisk can't read disk usage here; health checks leave it out and
+// MIN_FREE_DISK_SPACE has no effect
+func statDisk(path string) (diskUsage, error) {
+	return diskUsage{}, errDiskUsageUnsupported
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "syscall"
+
+// statDisk returns the space on the filesystem holding path
+func statDisk(path string) (diskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return diskUsage{}, err
+	}
+	return diskUsage{
+		Free:  uint64(st.Bavail) * uint64(st.Bsize),
+		Total: uint64(st.Blocks) * uint64(st.Bsize),
+	}, nil
+}
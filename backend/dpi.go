@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxDPI is the highest ?dpi= accepted, above what print workflows use
+const maxDPI = 4800
+
+// parseDPI parses the dpi parameter
+func parseDPI(s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n <= 0 || n > maxDPI {
+		return 0, fmt.Errorf("dpi must be an integer between 1 and %d", maxDPI)
+	}
+	return n, nil
+}
+
+// supportsDPI reports whether setDPI can record a resolution in data's format
+func supportsDPI(data []byte) bool {
+	format := sniffFormat(data)
+	return format == "jpeg" || format == "png"
+}
+
+// setDPI records a print resolution in the image's density metadata without
+// resampling it: the JFIF header for JPEG and the pHYs chunk for PNG. bimg
+// can't set the resolution libvips saves, so the container is edited
+// directly, after any metadata stripping. Other formats, and data that
+// doesn't parse, are returned as is.
+func setDPI(data []byte, dpi int) []byte {
+	if dpi <= 0 {
+		return data
+	}
+	switch sniffFormat(data) {
+	case "jpeg":
+		return jpegWithDensity(data, dpi)
+	case "png":
+		return pngWithDensity(data, dpi)
+	}
+	return data
+}
+
+// jpegWithDensity sets the density of the JFIF header, adding one after SOI
+// when the JPEG has none
+func jpegWithDensity(data []byte, dpi int) []byte {
+	if len(data) < 4 {
+		return data
+	}
+	if len(data) >= 18 && data[2] == 0xFF && data[3] == 0xE0 && string(data[6:11]) == "JFIF\x00" &&
+		binary.BigEndian.Uint16(data[4:]) >= 16 {
+		out := append([]byte(nil), data...)
+		out[13] = 1 // units: dots per inch
+		binary.BigEndian.PutUint16(out[14:], uint16(dpi))
+		binary.BigEndian.PutUint16(out[16:], uint16(dpi))
+		return out
+	}
+
+	// length, identifier, version 1.01, units, densities, no thumbnail
+	segment := []byte{0xFF, 0xE0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 1, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(segment[12:], uint16(dpi))
+	binary.BigEndian.PutUint16(segment[14:], uint16(dpi))
+	return splice(data, 2, segment)
+}
+
+// pngWithDensity replaces any pHYs chunks with one giving dpi in pixels per
+// metre, placed after IHDR as it must come before the image data
+func pngWithDensity(data []byte, dpi int) []byte {
+	const ihdrEnd = 8 + 12 + 13 // signature, then IHDR's length, type, data and CRC
+	if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return data
+	}
+
+	body := make([]byte, 9)
+	perMetre := uint32(math.Round(float64(dpi) / 0.0254))
+	binary.BigEndian.PutUint32(body, perMetre)
+	binary.BigEndian.PutUint32(body[4:], perMetre)
+	body[8] = 1 // unit: metre
+	chunk := make([]byte, 8, 12+len(body))
+	binary.BigEndian.PutUint32(chunk, uint32(len(body)))
+	copy(chunk[4:], "pHYs")
+	chunk = append(chunk, body...)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc[:]...)
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	for i := ihdrEnd; i < len(data); {
+		if i+12 > len(data) {
+			return data
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return data
+		}
+		if string(data[i+4:i+8]) != "pHYs" {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out
+}
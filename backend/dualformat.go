@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// dualFormats are the versions ?dual_format=true stores, in the order a
+// <picture> element lists them: the modern format first, the fallback last
+var dualFormats = []bimg.ImageType{bimg.WEBP, bimg.JPEG}
+
+// parseDualFormatOptions parses the upload's dual_format parameter. Each
+// version has its own format and content hash, so it can't be combined with
+// a format or an expected hash.
+func parseDualFormatOptions(c *app.RequestContext, opts uploadOptions) (bool, error) {
+	v := c.Query("dual_format")
+	if v == "" {
+		return false, nil
+	}
+	dual, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &httpError{consts.StatusBadRequest, "dual_format must be true or false"}
+	}
+	if !dual {
+		return false, nil
+	}
+
+	var errs validationErrors
+	if c.Query("format") != "" {
+		errs.add(&httpError{consts.StatusBadRequest, "dual_format can't be combined with format"})
+	}
+	if opts.ExpectedSHA256 != "" {
+		errs.add(&httpError{consts.StatusBadRequest, "dual_format can't be combined with X-Expected-SHA256"})
+	}
+	key, _ := requestAPIKey(c)
+	for _, format := range dualFormats {
+		switch {
+		case !canSave(format):
+			errs.add(unsupportedFormat(format))
+		case key.AllowedFormats != nil && !key.AllowedFormats[format]:
+			errs.add(&httpError{consts.StatusForbidden, fmt.Sprintf("format %s is not allowed for this API key", bimg.ImageTypeName(format))})
+		}
+	}
+	return true, errs.err()
+}
+
+// processDualFormat stores a WebP and a JPEG version of one upload. They
+// share a stored name and differ in extension, e.g. <timestamp>.webp and
+// <timestamp>.jpg. Each is compressed to the size target on its own. If
+// either fails, the one already stored is removed.
+func processDualFormat(ctx context.Context, originalName string, data []byte, opts uploadOptions) (map[string]interface{}, processTiming, error) {
+	var timing processTiming
+	var base string
+	var stored []string
+	versions := make([]map[string]interface{}, 0, len(dualFormats))
+	variants := make([]assetVariant, 0, len(dualFormats))
+	fail := func(err error) (map[string]interface{}, processTiming, error) {
+		for _, filename := range stored {
+			removeStoredFile(ctx, filename)
+		}
+		return nil, timing, err
+	}
+	for _, format := range dualFormats {
+		versionOpts := opts
+		versionOpts.Format = format
+		compressed, details, err := processImage(ctx, data, versionOpts)
+		timing.QueueWait += details.QueueWait
+		timing.Processing += details.Processing
+		if err != nil {
+			return fail(err)
+		}
+		// An encoder failure falls back to another format, which would leave
+		// two versions in the same one
+		ext, ok := detectedExtension(compressed)
+		if !ok || bimg.DetermineImageType(compressed) != format {
+			return fail(&httpError{consts.StatusInternalServerError, fmt.Sprintf("Failed to encode image as %s", bimg.ImageTypeName(format))})
+		}
+		if base == "" {
+			name := generateFilename(originalName, compressed)
+			base = strings.TrimSuffix(name, filepath.Ext(name))
+			if opts.Namespace != "" {
+				base = opts.Namespace + "-" + base
+			}
+		}
+
+		filename := base + ext
+		storing := time.Now()
+		result, err := storeProcessed(ctx, filename, data, details.Source, compressed, versionOpts)
+		timing.Storage += time.Since(storing)
+		if err != nil {
+			return fail(err)
+		}
+		if result["deduplicated"] == nil {
+			stored = append(stored, filename)
+		}
+		result["mime_type"] = imageContentType(compressed)
+		versions = append(versions, result)
+		variants = append(variants, assetVariant{Name: bimg.ImageTypeName(format), Filename: filename})
+	}
+
+	// Ready to render as <source srcset type> elements and an <img> fallback
+	sources := make([]map[string]interface{}, 0, len(versions)-1)
+	for _, version := range versions[:len(versions)-1] {
+		sources = append(sources, map[string]interface{}{
+			"srcset": version["url"],
+			"type":   version["mime_type"],
+		})
+	}
+	result := map[string]interface{}{
+		"original_size": len(data),
+		"versions":      versions,
+		"picture": map[string]interface{}{
+			"sources": sources,
+			"img":     versions[len(versions)-1]["url"],
+		},
+	}
+	if cfg.Assets && !cfg.DryRun {
+		addAsset(ctx, base, variants, result)
+	}
+	if !cfg.DryRun {
+		for _, version := range versions {
+			if version["deduplicated"] != nil {
+				continue
+			}
+			e := storedEvent(eventUploaded, version)
+			e.Asset, _ = result["asset"].(string)
+			events.Emit(e)
+		}
+	}
+	return result, timing, nil
+}
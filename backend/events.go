@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// Event types published for stored files
+const (
+	eventUploaded    = "uploaded"
+	eventReplaced    = "replaced"
+	eventReprocessed = "reprocessed"
+	eventDeleted     = "deleted"
+)
+
+// EVENT_PUBLISHER values
+const (
+	eventPublisherNone = "none"
+	eventPublisherNATS = "nats"
+)
+
+// event describes a change to a stored file, published as JSON
+type event struct {
+	Type     string            `json:"type"`
+	Time     time.Time         `json:"time"`
+	Filename string            `json:"filename"`
+	URL      string            `json:"url,omitempty"`
+	Asset    string            `json:"asset,omitempty"`
+	Size     int               `json:"size,omitempty"`
+	Format   string            `json:"format,omitempty"`
+	SHA256   string            `json:"sha256,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Reason   string            `json:"reason,omitempty"` // for deletions: "requested" or "expired"
+}
+
+// eventPublisher delivers events to a message broker
+type eventPublisher interface {
+	// Publish sends one event, blocking until it is handed to the broker
+	Publish(e event) error
+	// Close releases the connection
+	Close() error
+}
+
+// noopPublisher drops every event; it is used with EVENT_PUBLISHER=none
+type noopPublisher struct{}
+
+func (noopPublisher) Publish(event) error { return nil }
+func (noopPublisher) Close() error        { return nil }
+
+// newEventPublisher creates the publisher EVENT_PUBLISHER selects
+func newEventPublisher(c config) (eventPublisher, error) {
+	switch c.EventPublisher {
+	case eventPublisherNone:
+		return noopPublisher{}, nil
+	case eventPublisherNATS:
+		return newNATSPublisher(c.NatsURL, c.EventSubjectPrefix)
+	}
+	return nil, fmt.Errorf("invalid EVENT_PUBLISHER: %q (expected none or nats)", c.EventPublisher)
+}
+
+// eventQueue hands events to a publisher from a background goroutine, so a
+// slow or unreachable broker never holds up or fails a request. Events that
+// don't fit in the queue, or fail to publish, are logged and dropped.
+type eventQueue struct {
+	publisher eventPublisher
+	mu        sync.RWMutex
+	closed    bool
+	queue     chan event
+	done      chan struct{}
+}
+
+// events is the event queue in effect, started in main
+var events *eventQueue
+
+// startEventQueue starts delivering events to publisher, buffering up to size
+func startEventQueue(publisher eventPublisher, size int) *eventQueue {
+	q := &eventQueue{publisher: publisher, queue: make(chan event, size), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+// run publishes queued events until the queue is closed
+func (q *eventQueue) run() {
+	defer close(q.done)
+	for e := range q.queue {
+		if err := q.publisher.Publish(e); err != nil {
+			hlog.Warnf("events: failed to publish %s event for %s: %v", e.Type, e.Filename, err)
+		}
+	}
+}
+
+// Emit queues an event without waiting for it to be published
+func (q *eventQueue) Emit(e event) {
+	if _, noop := q.publisher.(noopPublisher); noop {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		hlog.Warnf("events: shutting down, dropping %s event for %s", e.Type, e.Filename)
+		return
+	}
+	select {
+	case q.queue <- e:
+	default:
+		hlog.Warnf("events: queue full, dropping %s event for %s", e.Type, e.Filename)
+	}
+}
+
+// Close publishes the events still queued, giving up when ctx ends, and
+// closes the publisher
+func (q *eventQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	close(q.queue)
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		hlog.Warnf("events: %d queued events not published before shutdown", len(q.queue))
+	}
+	return q.publisher.Close()
+}
+
+// storedEvent describes a stored file from the fields of an upload result
+func storedEvent(kind string, result map[string]interface{}) event {
+	e := event{Type: kind}
+	e.Filename, _ = result["filename"].(string)
+	e.URL, _ = result["url"].(string)
+	e.Asset, _ = result["asset"].(string)
+	e.Size, _ = result["compressed_size"].(int)
+	e.Format, _ = result["format"].(string)
+	e.SHA256, _ = result["sha256"].(string)
+	return e
+}
+
+// deletedEvent describes a stored file that was removed
+func deletedEvent(filename, reason string, record fileMeta) event {
+	e := event{Type: eventDeleted, Filename: filename, SHA256: record.SHA256, Tags: record.Tags, Reason: reason}
+	e.Asset, _ = assets.AssetOf(filename)
+	return e
+}
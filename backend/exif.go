@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+// exifDateLayout is how EXIF writes dates; it carries no time zone
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// captureDate returns when a photo was taken, from its EXIF DateTimeOriginal
+// tag. It must be read from the original upload, since compression may strip
+// the metadata. It returns false when the tag is absent or malformed.
+func captureDate(data []byte) (time.Time, bool) {
+	metadata, err := bimg.Metadata(data)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(exifDateLayout, strings.TrimSpace(metadata.EXIF.DateTimeOriginal))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// exifFields reports which privacy-relevant EXIF fields an image carries,
+// by the names used in the stripped_metadata summary
+func exifFields(data []byte) map[string]bool {
+	metadata, err := bimg.Metadata(data)
+	if err != nil {
+		return nil
+	}
+	exif := metadata.EXIF
+	return map[string]bool{
+		"gps":          exif.GPSLatitude != "" || exif.GPSLongitude != "",
+		"camera":       exif.Make != "" || exif.Model != "",
+		"capture_date": exif.DateTimeOriginal != "" || exif.Datetime != "",
+		"software":     exif.Software != "",
+	}
+}
+
+// strippedMetadata summarizes which EXIF fields of the original were
+// removed from the stored image: each field the original had maps to
+// whether it is gone. Values are never included. It returns nil when the
+// original had none of the fields.
+func strippedMetadata(original, stored []byte) map[string]interface{} {
+	before, after := exifFields(original), exifFields(stored)
+	summary := make(map[string]interface{})
+	for field, present := range before {
+		if present {
+			summary[field] = !after[field]
+		}
+	}
+	if len(summary) == 0 {
+		return nil
+	}
+	return summary
+}
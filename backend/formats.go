@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/h2non/bimg"
+)
+
+// outputFormats are the values accepted by the format parameter
+var outputFormats = map[string]bimg.ImageType{
+	"jpeg": bimg.JPEG,
+	"jpg":  bimg.JPEG,
+	"png":  bimg.PNG,
+	"webp": bimg.WEBP,
+	"avif": bimg.AVIF,
+}
+
+// fallbackFormats are tried in order when encoding to the requested format fails
+var fallbackFormats = []bimg.ImageType{bimg.WEBP, bimg.JPEG}
+
+// parseOutputFormat parses the format upload parameter
+func parseOutputFormat(s string) (bimg.ImageType, error) {
+	format, ok := outputFormats[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return bimg.UNKNOWN, fmt.Errorf("format must be one of jpeg, png, webp or avif")
+	}
+	return format, nil
+}
+
+// parseInputFormat normalizes an accepted upload format name, as named by
+// sniffFormat
+func parseInputFormat(s string) (string, bool) {
+	input := strings.ToLower(strings.TrimSpace(s))
+	if input == "jpg" {
+		input = "jpeg"
+	}
+	switch input {
+	case "jpeg", "png", "gif", "webp", "bmp":
+		return input, true
+	}
+	return "", false
+}
+
+// parsePassthroughSizes parses PASSTHROUGH_MAX_SIZES, a comma-separated list
+// of "<input>:<size>" entries such as "webp:64KB,png:16KB"
+func parsePassthroughSizes(s string) (map[string]int, error) {
+	sizes := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("entry %q must be <input>:<size>", entry)
+		}
+		input, ok := parseInputFormat(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown input format %q in entry %q", parts[0], entry)
+		}
+		size, err := parseByteSize(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %v", entry, err)
+		}
+		sizes[input] = size
+	}
+	return sizes, nil
+}
+
+// passthrough returns the upload unchanged when its format is listed in
+// PASSTHROUGH_MAX_SIZES and it is within that size, since re-encoding tiny
+// images wastes CPU and can even enlarge them. It takes precedence over
+// FORMAT_MAP and FORCE_OUTPUT_FORMAT, but not over an explicit format, a
+// resize, or the colour fixes compressImage always applies. Metadata that
+// must be stripped is dropped losslessly from upright JPEGs, and otherwise
+// kept.
+func passthrough(data []byte, opts uploadOptions) ([]byte, bool) {
+	limit, ok := cfg.PassthroughSizes[sniffFormat(data)]
+	if !ok || len(data) > limit || (opts.MaxBytes > 0 && len(data) > opts.MaxBytes) {
+		return nil, false
+	}
+	if opts.Format != bimg.UNKNOWN || opts.Width > 0 || opts.Height > 0 || opts.Quality > 0 {
+		return nil, false
+	}
+	img := bimg.NewImage(data)
+	if isCMYK(img) || (cfg.AlphaPolicy == alphaFlatten && hasAlpha(img)) || profileNeedsChange(img) {
+		return nil, false
+	}
+	if _, ok := eightBitInterpretation(img); ok && cfg.NormalizeBitDepth {
+		return nil, false
+	}
+	if cfg.CopyrightText != "" && bimg.DetermineImageType(data) == bimg.JPEG && isUprightJPEG(img) {
+		if stripped, ok := stripJPEGMetadata(data); ok {
+			return stripped, true
+		}
+	}
+	return data, true
+}
+
+// parseFormatMap parses FORMAT_MAP, a comma-separated list of
+// "<input>:<output>" rules such as "png:webp,bmp:jpeg". Inputs are the
+// accepted upload formats and outputs the values of the format parameter.
+func parseFormatMap(s string) (map[string]bimg.ImageType, error) {
+	rules := make(map[string]bimg.ImageType)
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		parts := strings.Split(rule, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("rule %q must be <input>:<output>", rule)
+		}
+		input, ok := parseInputFormat(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown input format %q in rule %q", parts[0], rule)
+		}
+		output, err := parseOutputFormat(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", rule, err)
+		}
+		rules[input] = output
+	}
+	return rules, nil
+}
+
+// outputFormat resolves the format an image is encoded as: the format
+// parameter when given (only allowed with FORCE_OUTPUT_FORMAT when
+// ALLOW_FORMAT_OVERRIDE is set), then FORCE_OUTPUT_FORMAT, then the
+// FORMAT_MAP rule for the input's format. bimg.UNKNOWN means the input's
+// own format is kept.
+func outputFormat(data []byte, requested bimg.ImageType) bimg.ImageType {
+	if requested != bimg.UNKNOWN {
+		return requested
+	}
+	if cfg.ForceOutputFormat != bimg.UNKNOWN {
+		return cfg.ForceOutputFormat
+	}
+	if mapped, ok := cfg.FormatMap[sniffFormat(data)]; ok {
+		return mapped
+	}
+	return bimg.UNKNOWN
+}
+
+// compressWithFallback compresses data to the requested format. If libvips
+// fails to encode it (some AVIF builds choke on certain images), or this
+// build has no encoder for it, it retries with each fallback format the
+// build supports, logging every fallback. With no requested or mapped format
+// the input's own format is kept and there is no fallback.
+func compressWithFallback(ctx context.Context, data []byte, opts uploadOptions) ([]byte, error) {
+	if kept, ok := passthrough(data, opts); ok {
+		return kept, nil
+	}
+	opts.Format = outputFormat(data, opts.Format)
+	if opts.Format == bimg.UNKNOWN {
+		return compressImage(ctx, data, opts)
+	}
+	var compressed []byte
+	err := unsupportedFormat(opts.Format)
+	if canSave(opts.Format) {
+		if compressed, err = compressImage(ctx, data, opts); err == nil {
+			return compressed, nil
+		}
+		if _, ok := err.(*httpError); ok {
+			return nil, err // the image itself was refused, not the encoder
+		}
+	}
+	for _, fallback := range fallbackFormats {
+		if fallback == opts.Format || !canSave(fallback) {
+			continue
+		}
+		hlog.CtxWarnf(ctx, "encoding as %s failed, falling back to %s: %v",
+			bimg.ImageTypeName(opts.Format), bimg.ImageTypeName(fallback), err)
+		opts.Format = fallback
+		if compressed, err = compressImage(ctx, data, opts); err == nil {
+			return compressed, nil
+		}
+	}
+	return nil, err
+}
+
+// isLosslessWebP reports whether data is a WebP image encoded losslessly,
+// i.e. its image data is in a VP8L chunk rather than a lossy VP8 one
+func isLosslessWebP(data []byte) bool {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return false
+	}
+	for pos := 12; pos+8 <= len(data); {
+		switch string(data[pos : pos+4]) {
+		case "VP8L":
+			return true
+		case "VP8 ":
+			return false
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8 + size + size&1
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// responseHeader is a static header added to responses
+type responseHeader struct {
+	name, value string
+}
+
+// defaultUploadsHeaders are always sent with served uploads. User content
+// must never be sniffed into something executable, such as HTML.
+var defaultUploadsHeaders = []responseHeader{
+	{"X-Content-Type-Options", "nosniff"},
+}
+
+// parseResponseHeaders parses a "|"-separated list of "Name: value" headers,
+// e.g. "Cross-Origin-Resource-Policy: cross-origin|Cache-Control: public, max-age=86400".
+// The defaults can't be configured, and come last so nothing replaces them.
+func parseResponseHeaders(s string) ([]responseHeader, error) {
+	var headers []responseHeader
+	for _, entry := range strings.Split(s, "|") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("header %q must be Name: value", entry)
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		if !validHeaderValue(value) {
+			return nil, fmt.Errorf("invalid value for header %s", name)
+		}
+		for _, h := range defaultUploadsHeaders {
+			if strings.EqualFold(name, h.name) {
+				return nil, fmt.Errorf("header %s is always sent as %q and can't be configured", h.name, h.value)
+			}
+		}
+		headers = append(headers, responseHeader{name, value})
+	}
+	return append(headers, defaultUploadsHeaders...), nil
+}
+
+// validHeaderName reports whether name is a non-empty RFC 7230 token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue reports whether value has no control characters, which
+// would allow splitting the response
+func validHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// setHeaders adds headers to the response, replacing any of the same name
+func setHeaders(c *app.RequestContext, headers []responseHeader) {
+	for _, h := range headers {
+		c.Response.Header.Set(h.name, h.value)
+	}
+}
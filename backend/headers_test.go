@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseResponseHeaders(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []responseHeader
+		wantErr bool
+	}{
+		{"", []responseHeader{{"X-Content-Type-Options", "nosniff"}}, false},
+		{"Cache-Control: public, max-age=60", []responseHeader{{"Cache-Control", "public, max-age=60"}, {"X-Content-Type-Options", "nosniff"}}, false},
+		{"X-Content-Type-Options: sniff", nil, true},
+		{"x-content-type-options: nosniff", nil, true},
+		{"Bad Name: x", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseResponseHeaders(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseResponseHeaders(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseResponseHeaders(%q) = %v, want %v", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseResponseHeaders(%q) = %v, want %v", tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+}
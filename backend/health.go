@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// checkWritableDir verifies that dir is a directory files can be created in
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %v", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+// handleLiveness only confirms the process is responding, so a busy but
+// healthy server is never restarted because a dependency is slow
+func handleLiveness(ctx context.Context, c *app.RequestContext) {
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"status": "ok",
+	})
+}
+
+// handleReadiness checks that uploads can currently be served and stored,
+// answering 503 with the failing checks otherwise. With disk storage it also
+// reports the free and total space of the uploads filesystem.
+func handleReadiness(ctx context.Context, c *app.RequestContext) {
+	checks := map[string]interface{}{}
+	ready := true
+	for name, check := range map[string]func() error{
+		"storage":  store.Check,
+		"metadata": meta.Check,
+		"temp_dir": func() error { return checkWritableDir(cfg.TempDir) },
+	} {
+		if err := check(); err != nil {
+			checks[name] = err.Error()
+			ready = false
+		} else {
+			checks[name] = "ok"
+		}
+	}
+
+	status, body := consts.StatusOK, map[string]interface{}{"status": "ok", "checks": checks}
+	if usage, ok, err := uploadsDiskUsage(); err != nil {
+		checks["disk_space"] = err.Error()
+		ready = false
+	} else if ok {
+		body["disk"] = diskUsageBody(usage)
+		if cfg.MinFreeDiskSpace > 0 {
+			checks["disk_space"] = "ok"
+			if err := checkFreeDiskSpace(ctx); err != nil {
+				checks["disk_space"] = err.Error()
+				ready = false
+			}
+		}
+	}
+	if !ready {
+		status, body["status"] = consts.StatusServiceUnavailable, "unavailable"
+	}
+	respond(c, status, body)
+}
+
+// handleStats reports how much is stored: the number and total size of the
+// stored files and, with disk storage, the free and total space of the
+// uploads filesystem. Unlike /healthz it never fails on low disk space.
+func handleStats(ctx context.Context, c *app.RequestContext) {
+	files, err := store.List()
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to list files",
+		})
+		return
+	}
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	body := map[string]interface{}{
+		"files":        len(files),
+		"stored_bytes": total,
+	}
+	if usage, ok, err := uploadsDiskUsage(); err != nil {
+		hlog.CtxWarnf(ctx, "failed to read free disk space: %v", err)
+	} else if ok {
+		body["disk"] = diskUsageBody(usage)
+	}
+	respond(c, consts.StatusOK, body)
+}
+
+// diskUsageBody is the "disk" object of /healthz and /stats
+func diskUsageBody(usage diskUsage) map[string]interface{} {
+	return map[string]interface{}{
+		"free_bytes":  usage.Free,
+		"total_bytes": usage.Total,
+	}
+}
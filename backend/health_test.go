@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	mem := setupTestServer(t, nil)
+	engine := newTestEngine()
+	engine.GET("/stats", handleStats)
+	mem.Save("a.png", make([]byte, 100))
+	mem.Save("b.png", make([]byte, 50))
+
+	t.Run("memory storage", func(t *testing.T) {
+		w := performGet(engine, "/stats")
+		if w.Code != 200 {
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+		result := decodeJSON(t, w)
+		if result["files"] != float64(2) || result["stored_bytes"] != float64(150) {
+			t.Errorf("files %v, stored_bytes %v, want 2 and 150", result["files"], result["stored_bytes"])
+		}
+		if _, ok := result["disk"]; ok {
+			t.Errorf("disk reported for memory storage: %v", result["disk"])
+		}
+	})
+
+	t.Run("disk storage", func(t *testing.T) {
+		uploadsVolume = t.TempDir()
+		t.Cleanup(func() { uploadsVolume = "" })
+		if _, ok, _ := uploadsDiskUsage(); !ok {
+			t.Skip("disk usage is not available on this platform")
+		}
+		result := decodeJSON(t, performGet(engine, "/stats"))
+		disk, ok := result["disk"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("no disk figures: %s", result)
+		}
+		if disk["total_bytes"].(float64) <= 0 || disk["free_bytes"].(float64) > disk["total_bytes"].(float64) {
+			t.Errorf("implausible disk figures: %v", disk)
+		}
+	})
+}
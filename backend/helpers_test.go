@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"testing"
+
+	hertzconfig "github.com/cloudwego/hertz/pkg/common/config"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/route"
+)
+
+// setupTestServer loads the configuration from env on top of the defaults
+// and points the package at fresh in-memory storage and indexes, so handler
+// tests never touch the disk. It returns the storage to assert against.
+func setupTestServer(t *testing.T, env map[string]string) *memoryStorage {
+	t.Helper()
+	t.Setenv("STORAGE_BACKEND", "memory")
+	t.Setenv("TEMP_DIR", t.TempDir())
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	var err error
+	if cfg, err = loadConfig(); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	probeFormats()
+
+	mem := newMemoryStorage()
+	store = mem
+	meta = newMetadataStore("")
+	phashes, _ = loadPHashIndex("")
+	shortIDs, _ = loadShortIDIndex("")
+	assets, _ = loadAssetIndex("")
+	events = startEventQueue(noopPublisher{}, 1)
+	pool = newWorkerPool(cfg.ProcessingWorkers)
+	return mem
+}
+
+// newTestEngine returns a router for registering the handlers under test
+func newTestEngine() *route.Engine {
+	return route.NewEngine(hertzconfig.NewOptions(nil))
+}
+
+// postImage sends data as a multipart upload in the "image" field
+func postImage(engine *route.Engine, url, filename string, data []byte) *ut.ResponseRecorder {
+	return sendImage(engine, "POST", url, filename, data)
+}
+
+// sendImage sends data as a multipart upload in the "image" field with any
+// method and extra headers
+func sendImage(engine *route.Engine, method, url, filename string, data []byte, headers ...ut.Header) *ut.ResponseRecorder {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("image", filename)
+	part.Write(data)
+	w.Close()
+	headers = append(headers, ut.Header{Key: "Content-Type", Value: w.FormDataContentType()})
+	return ut.PerformRequest(engine, method, url, &ut.Body{Body: &body, Len: body.Len()}, headers...)
+}
+
+// performGet sends a GET request
+func performGet(engine *route.Engine, url string, headers ...ut.Header) *ut.ResponseRecorder {
+	return ut.PerformRequest(engine, "GET", url, nil, headers...)
+}
+
+// decodeJSON decodes a JSON response body
+func decodeJSON(t *testing.T, w *ut.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var result map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, w.Body.String())
+	}
+	return result
+}
+
+// assertStored fails unless the storage holds exactly the named files
+func assertStored(t *testing.T, mem *memoryStorage, names ...string) {
+	t.Helper()
+	got := mem.Names()
+	if len(got) != len(names) {
+		t.Fatalf("stored files = %v, want %v", got, names)
+	}
+	for i := range names {
+		if got[i] != names[i] {
+			t.Fatalf("stored files = %v, want %v", got, names)
+		}
+	}
+}
+
+// testImage returns a w x h gradient, opaque unless alpha is below 255
+func testImage(w, h int, alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), 100, alpha})
+		}
+	}
+	return img
+}
+
+// testPNG encodes a w x h PNG
+func testPNG(t testing.TB, w, h int, alpha uint8) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(w, h, alpha)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testJPEG encodes a w x h JPEG
+func testJPEG(t testing.TB, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(w, h, 255), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
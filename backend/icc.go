@@ -0,0 +1,39 @@
+package main
+
+import "github.com/h2non/bimg"
+
+// ICC_PROFILE_POLICY values: what becomes of an upload's embedded colour profile
+const (
+	iccKeepAll  = "keep-all"  // keep the original profile
+	iccKeepSRGB = "keep-srgb" // convert to sRGB and embed the sRGB profile
+	iccStrip    = "strip"     // drop the profile, leaving the output untagged
+)
+
+// hasProfile reports whether an image embeds an ICC colour profile
+func hasProfile(img *bimg.Image) bool {
+	meta, err := img.Metadata()
+	return err == nil && meta.Profile
+}
+
+// profileNeedsChange reports whether ICC_PROFILE_POLICY requires the image
+// to be re-encoded: it has a profile and the policy doesn't keep it as is
+func profileNeedsChange(img *bimg.Image) bool {
+	return cfg.ICCProfilePolicy != iccKeepAll && hasProfile(img)
+}
+
+// applyProfilePolicy sets the encoding options ICC_PROFILE_POLICY asks for
+// and reports whether they change the image. keep-srgb converts through the
+// embedded profile to libvips's built-in sRGB profile, which is embedded in
+// its place. strip removes the profile without converting, so wide-gamut
+// images lose some saturation when displayed as sRGB.
+func applyProfilePolicy(o *bimg.Options, img *bimg.Image) bool {
+	if !profileNeedsChange(img) {
+		return false
+	}
+	if cfg.ICCProfilePolicy == iccStrip {
+		o.NoProfile = true
+	} else {
+		o.OutputICC = "srgb"
+	}
+	return true
+}
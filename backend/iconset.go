@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// iconSizes are the icon sizes ?iconset=true generates, largest first:
+// favicons (16, 32, 48), the Apple touch icon (180) and the web app
+// manifest icons (192, 512)
+var iconSizes = []int{512, 192, 180, 48, 32, 16}
+
+// parseIconSetOptions parses the upload's iconset parameter, and crop, which
+// lets a non-square source be cropped around its centre. An icon set has its
+// own sizes and a stored image per size, so it can't be combined with a
+// resize box or an expected hash.
+func parseIconSetOptions(c *app.RequestContext, opts *uploadOptions) (bool, error) {
+	v := c.Query("iconset")
+	if v == "" {
+		return false, nil
+	}
+	iconSet, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &httpError{consts.StatusBadRequest, "iconset must be true or false"}
+	}
+	if !iconSet {
+		return false, nil
+	}
+
+	var errs validationErrors
+	if opts.Width != 0 || opts.Height != 0 {
+		errs.add(&httpError{consts.StatusBadRequest, "iconset can't be combined with width or height"})
+	}
+	if opts.ExpectedSHA256 != "" {
+		errs.add(&httpError{consts.StatusBadRequest, "iconset can't be combined with X-Expected-SHA256"})
+	}
+	opts.Fit = fitInside
+	if v := c.Query("crop"); v != "" {
+		crop, err := strconv.ParseBool(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, "crop must be true or false"})
+		}
+		if crop {
+			opts.Fit = fitCover
+		}
+	}
+	return true, errs.err()
+}
+
+// checkIconSource refuses icon set sources that are too small for the
+// largest icon, or that aren't square unless crop=true crops them
+func checkIconSource(data []byte, opts uploadOptions) error {
+	dims, err := bimg.Size(data)
+	if err != nil {
+		return nil // reported by checkImage
+	}
+	var errs validationErrors
+	if dims.Width != dims.Height && opts.Fit != fitCover {
+		errs.add(&httpError{consts.StatusBadRequest, fmt.Sprintf("Icon source must be square, not %dx%d; set crop=true to crop it", dims.Width, dims.Height)})
+	}
+	if largest := iconSizes[0]; dims.Width < largest || dims.Height < largest {
+		errs.add(&httpError{consts.StatusBadRequest, fmt.Sprintf("Icon source must be at least %dx%d, not %dx%d", largest, largest, dims.Width, dims.Height)})
+	}
+	return errs.err()
+}
+
+// processIconSet renders and stores every icon size from one source image.
+// The icons share the stored name of the largest with a size suffix, e.g.
+// <timestamp>-32x32.png. They are PNG unless the format parameter asks
+// otherwise. If any icon fails, the ones already stored are removed.
+func processIconSet(ctx context.Context, originalName string, data []byte, opts uploadOptions) (map[string]interface{}, processTiming, error) {
+	var timing processTiming
+	if opts.Format == bimg.UNKNOWN {
+		opts.Format = bimg.PNG
+	}
+
+	var base, ext string
+	var stored []string
+	icons := make([]map[string]interface{}, 0, len(iconSizes))
+	manifest := make([]map[string]interface{}, 0, len(iconSizes))
+	variants := make([]assetVariant, 0, len(iconSizes))
+	fail := func(err error) (map[string]interface{}, processTiming, error) {
+		for _, filename := range stored {
+			removeStoredFile(ctx, filename)
+		}
+		return nil, timing, err
+	}
+	for _, size := range iconSizes {
+		iconOpts := opts
+		iconOpts.Width, iconOpts.Height = size, size
+		compressed, details, err := processImage(ctx, data, iconOpts)
+		timing.QueueWait += details.QueueWait
+		timing.Processing += details.Processing
+		if err != nil {
+			return fail(err)
+		}
+		if base == "" {
+			name := generateFilename(originalName, compressed)
+			ext = filepath.Ext(name)
+			base = strings.TrimSuffix(name, ext)
+			if opts.Namespace != "" {
+				base = opts.Namespace + "-" + base
+			}
+		}
+
+		filename := fmt.Sprintf("%s-%dx%d%s", base, size, size, ext)
+		storing := time.Now()
+		result, err := storeProcessed(ctx, filename, data, details.Source, compressed, iconOpts)
+		timing.Storage += time.Since(storing)
+		if err != nil {
+			return fail(err)
+		}
+		if result["deduplicated"] == nil {
+			stored = append(stored, filename)
+		}
+		result["size"] = size
+		icons = append(icons, result)
+		variants = append(variants, assetVariant{Name: fmt.Sprintf("%dx%d", size, size), Filename: filename})
+		manifest = append(manifest, map[string]interface{}{
+			"src":   result["url"],
+			"sizes": fmt.Sprintf("%dx%d", size, size),
+			"type":  "image/" + bimg.DetermineImageTypeName(compressed),
+		})
+	}
+
+	result := map[string]interface{}{
+		"original_size": len(data),
+		"icons":         icons,
+		"manifest":      map[string]interface{}{"icons": manifest},
+	}
+	if cfg.Assets && !cfg.DryRun {
+		addAsset(ctx, base, variants, result)
+	}
+	if !cfg.DryRun {
+		for _, icon := range icons {
+			if icon["deduplicated"] != nil {
+				continue
+			}
+			e := storedEvent(eventUploaded, icon)
+			e.Asset, _ = result["asset"].(string)
+			events.Emit(e)
+		}
+	}
+	return result, timing, nil
+}
+
+// removeStoredFile deletes a stored file with its metadata and index entries
+func removeStoredFile(ctx context.Context, filename string) {
+	if err := store.Delete(filename); err != nil && err != errNotFound {
+		hlog.CtxWarnf(ctx, "failed to delete %s: %v", filename, err)
+	}
+	if err := meta.Delete(filename); err != nil {
+		hlog.CtxWarnf(ctx, "failed to delete metadata for %s: %v", filename, err)
+	}
+	if err := phashes.Remove(filename); err != nil {
+		hlog.CtxWarnf(ctx, "failed to update phash index for %s: %v", filename, err)
+	}
+	if err := shortIDs.Remove(filename); err != nil {
+		hlog.CtxWarnf(ctx, "failed to update short ID index for %s: %v", filename, err)
+	}
+	if err := assets.Remove(filename); err != nil {
+		hlog.CtxWarnf(ctx, "failed to update asset index for %s: %v", filename, err)
+	}
+}
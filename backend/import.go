@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// errBlockedAddress is returned when a fetch would connect to a non-public address
+var errBlockedAddress = errors.New("destination address is not allowed")
+
+// fetchClient downloads remote images. Its dialer refuses to connect to
+// loopback, private, link-local and other non-public addresses, which also
+// covers redirects and DNS names resolving to internal hosts.
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return errBlockedAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.New("redirect to unsupported scheme")
+		}
+		return nil
+	},
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	// "This network" and the carrier-grade NAT range, commonly used internally
+	if ip4 := ip.To4(); ip4 != nil && (ip4[0] == 0 || (ip4[0] == 100 && ip4[1]&0xc0 == 64)) {
+		return false
+	}
+	return true
+}
+
+// fetchImage downloads a remote image, enforcing FETCH_TIMEOUT and FETCH_MAX_BYTES
+func fetchImage(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.FetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL")
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errBlockedAddress) {
+			return nil, errBlockedAddress
+		}
+		return nil, fmt.Errorf("fetch failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch failed: remote returned %d", resp.StatusCode)
+	}
+	if resp.ContentLength > int64(cfg.FetchMaxBytes) {
+		return nil, fmt.Errorf("remote image exceeds %d bytes", cfg.FetchMaxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(cfg.FetchMaxBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %v", err)
+	}
+	if len(data) > cfg.FetchMaxBytes {
+		return nil, fmt.Errorf("remote image exceeds %d bytes", cfg.FetchMaxBytes)
+	}
+	return data, nil
+}
+
+// importImage fetches one URL and runs it through the upload pipeline,
+// returning the fetched bytes along with the outcome
+func importImage(ctx context.Context, rawURL string) (map[string]interface{}, []byte, error) {
+	data, err := fetchImage(ctx, rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Name the upload after the URL path, or after the detected format when
+	// the path has no image extension
+	u, _ := url.Parse(rawURL)
+	name := path.Base(u.Path)
+	if !isImageFile(name) {
+		ext, ok := detectedExtension(data)
+		if !ok {
+			return nil, data, errors.New("Fetched file is not a valid image")
+		}
+		name = "image" + ext
+	}
+
+	if _, err := validateImage(ctx, data); err != nil {
+		return nil, data, err
+	}
+	result, _, err := processUpload(ctx, name, data, defaultUploadOptions())
+	return result, data, err
+}
+
+// handleImport handles bulk import of remote images from a JSON array of URLs.
+// URLs are fetched concurrently, and each one's outcome is reported
+// separately so a failed URL doesn't abort the rest of the batch.
+func handleImport(ctx context.Context, c *app.RequestContext) {
+	var urls []string
+	if err := json.Unmarshal(c.Request.Body(), &urls); err != nil {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Request body must be a JSON array of URLs",
+		})
+		return
+	}
+	if len(urls) == 0 {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "No URLs to import",
+		})
+		return
+	}
+	if len(urls) > cfg.ImportMaxURLs {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": fmt.Sprintf("Too many URLs: at most %d per request", cfg.ImportMaxURLs),
+		})
+		return
+	}
+
+	// Fetch with at most one download per processing worker in flight
+	clientIP := c.ClientIP()
+	results := make([]map[string]interface{}, len(urls))
+	fetchers := make(chan struct{}, cfg.ProcessingWorkers)
+	var wg sync.WaitGroup
+	for i, rawURL := range urls {
+		wg.Add(1)
+		go func(i int, rawURL string) {
+			defer wg.Done()
+			fetchers <- struct{}{}
+			defer func() { <-fetchers }()
+
+			result, data, err := importImage(ctx, rawURL)
+			entry := newAuditEntry(clientIP, "import", data, result, err)
+			entry.SourceURL = rawURL
+			audit.Record(entry)
+			if err != nil {
+				result = map[string]interface{}{"error": err.Error()}
+			}
+			result["source_url"] = rawURL
+			results[i] = result
+		}(i, rawURL)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if _, ok := result["error"]; ok {
+			failed++
+		}
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"imported": len(results) - failed,
+		"failed":   failed,
+		"results":  results,
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// unsafeZipPath reports whether an entry name is absolute or climbs out of
+// the archive root (zip-slip)
+func unsafeZipPath(name string) bool {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return true
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// readZipEntry reads one entry, refusing entries that inflate beyond
+// ZIP_IMPORT_MAX_RATIO times their compressed size or past limit bytes.
+// The declared sizes are checked first, then enforced while reading since
+// they can be forged.
+func readZipEntry(f *zip.File, limit int64) ([]byte, error) {
+	maxSize := int64(f.CompressedSize64) * int64(cfg.ZipImportMaxRatio)
+	if maxSize > limit {
+		maxSize = limit
+	}
+	if int64(f.UncompressedSize64) > maxSize {
+		return nil, fmt.Errorf("entry inflates past %d bytes", maxSize)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("entry inflates past %d bytes", maxSize)
+	}
+	return data, nil
+}
+
+// handleImportZip imports every image in a zip archive sent as the request
+// body. Entries are decompressed and run through the upload pipeline one at
+// a time, and each one's outcome is reported separately. Directories are
+// ignored and other non-image entries are skipped with a note.
+func handleImportZip(ctx context.Context, c *app.RequestContext) {
+	if err := checkContentLength(c); err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	body := c.Request.Body()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Request body must be a zip archive",
+		})
+		return
+	}
+	if len(zr.File) > cfg.ZipImportMaxEntries {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": fmt.Sprintf("Too many entries: at most %d per archive", cfg.ZipImportMaxEntries),
+		})
+		return
+	}
+
+	clientIP := c.ClientIP()
+	remaining := int64(cfg.ZipImportMaxBytes)
+	results := make([]map[string]interface{}, 0, len(zr.File))
+	imported, failed := 0, 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := path.Base(strings.ReplaceAll(f.Name, `\`, "/"))
+		skip := func(reason string) {
+			results = append(results, map[string]interface{}{"entry": f.Name, "skipped": reason})
+		}
+		if unsafeZipPath(f.Name) {
+			skip("unsafe path")
+			continue
+		}
+		if filepath.Ext(name) != "" && !isImageFile(name) {
+			skip("not an image")
+			continue
+		}
+		if remaining <= 0 {
+			skip("archive exceeds the total uncompressed size limit")
+			continue
+		}
+
+		limit := remaining
+		if limit > int64(cfg.MaxUploadSize) {
+			limit = int64(cfg.MaxUploadSize)
+		}
+		data, err := readZipEntry(f, limit)
+		if err != nil {
+			skip(err.Error())
+			continue
+		}
+		remaining -= int64(len(data))
+		if filepath.Ext(name) == "" {
+			ext, ok := detectedExtension(data)
+			if !ok {
+				skip("not an image")
+				continue
+			}
+			name += ext
+		}
+
+		var result map[string]interface{}
+		if _, err = validateImage(ctx, data); err == nil {
+			result, _, err = processUpload(ctx, name, data, defaultUploadOptions())
+		}
+		entry := newAuditEntry(clientIP, "import-zip", data, result, err)
+		entry.OriginalName = f.Name
+		audit.Record(entry)
+		if err != nil {
+			result = map[string]interface{}{"error": err.Error()}
+			failed++
+		} else {
+			imported++
+		}
+		result["entry"] = f.Name
+		results = append(results, result)
+	}
+
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"imported": imported,
+		"failed":   failed,
+		"skipped":  len(results) - imported - failed,
+		"results":  results,
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// colorInfo is an image's colorspace and channel layout, read from libvips's
+// image properties
+type colorInfo struct {
+	Colorspace string // "srgb", "cmyk", "grayscale", or libvips's own name
+	Channels   int    // including any alpha channel
+	HasAlpha   bool
+}
+
+// imageColorInfo reads the colorspace and channels of an image from its header
+func imageColorInfo(data []byte) (colorInfo, error) {
+	metadata, err := bimg.Metadata(data)
+	if err != nil {
+		return colorInfo{}, err
+	}
+	info := colorInfo{Channels: metadata.Channels, HasAlpha: metadata.Alpha}
+	interpretation, err := bimg.ImageInterpretation(data)
+	if err != nil {
+		interpretation = bimg.InterpretationError
+	}
+	switch interpretation {
+	case bimg.InterpretationSRGB, bimg.InterpretationRGB, bimg.InterpretationRGB16:
+		info.Colorspace = "srgb"
+	case bimg.InterpretationCMYK:
+		info.Colorspace = "cmyk"
+	case bimg.InterpretationBW, bimg.InterpretationGREY16:
+		info.Colorspace = "grayscale"
+	default:
+		info.Colorspace = strings.ToLower(metadata.Space)
+	}
+	if info.Colorspace == "" {
+		info.Colorspace = "unknown"
+	}
+	return info, nil
+}
+
+// handleImageInfo describes a stored image: its format, dimensions, size,
+// colorspace and channels. Only the image header is read, so it is cheap
+// and doesn't wait for a processing worker.
+func handleImageInfo(ctx context.Context, c *app.RequestContext) {
+	filename := c.Param("filename")
+	if !validStoredName(filename) {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid filename",
+		})
+		return
+	}
+	data, err := store.Read(filename)
+	if err == errNotFound {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "File not found",
+		})
+		return
+	}
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to read file",
+		})
+		return
+	}
+
+	dims, err := bimg.Size(data)
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to read image",
+		})
+		return
+	}
+	info, err := imageColorInfo(data)
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to read image",
+		})
+		return
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"filename":   filename,
+		"format":     bimg.DetermineImageTypeName(data),
+		"width":      dims.Width,
+		"height":     dims.Height,
+		"size":       len(data),
+		"colorspace": info.Colorspace,
+		"channels":   info.Channels,
+		"has_alpha":  info.HasAlpha,
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// Integrity check outcomes
+const (
+	integrityOK      = "ok"
+	integrityCorrupt = "corrupt"
+	integrityUnknown = "unknown" // no hash was recorded for the file
+)
+
+// contentHash returns the hex SHA-256 of stored bytes
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// expectedHash returns the hash a stored file should have: the one recorded
+// at upload, or under content-hash naming the hash in its name
+func expectedHash(name string, record fileMeta) (string, bool) {
+	if record.SHA256 != "" {
+		return record.SHA256, true
+	}
+	if cfg.FilenameScheme == "content-hash" {
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		if _, err := hex.DecodeString(stem); err == nil && len(stem) == sha256.Size*2 {
+			return stem, true
+		}
+	}
+	return "", false
+}
+
+// verifyFile recomputes the hash of a stored file and compares it with the
+// expected one. It returns errNotFound when the file doesn't exist.
+func verifyFile(name string) (status, sum string, err error) {
+	data, err := store.Read(name)
+	if err != nil {
+		return "", "", err
+	}
+	record, _, err := meta.Get(name)
+	if err != nil {
+		return "", "", err
+	}
+	sum = contentHash(data)
+	expected, ok := expectedHash(name, record)
+	switch {
+	case !ok:
+		return integrityUnknown, sum, nil
+	case expected != sum:
+		return integrityCorrupt, sum, nil
+	}
+	return integrityOK, sum, nil
+}
+
+// handleVerifyFile reports whether a stored file still matches its hash
+func handleVerifyFile(ctx context.Context, c *app.RequestContext) {
+	filename := c.Param("filename")
+	if !validStoredName(filename) {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid filename",
+		})
+		return
+	}
+	status, sum, err := verifyFile(filename)
+	if err == errNotFound {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "File not found",
+		})
+		return
+	}
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to verify file",
+		})
+		return
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"filename": filename,
+		"status":   status,
+		"sha256":   sum,
+	})
+}
+
+// startScrubber verifies every stored file each interval in the background,
+// logging the ones that no longer match their hash
+func startScrubber(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if corrupt := scrubAll(); corrupt > 0 {
+				hlog.Errorf("scrub: found %d corrupt uploads", corrupt)
+			}
+		}
+	}()
+}
+
+// scrubAll verifies every stored file and returns how many are corrupt
+func scrubAll() int {
+	files, err := store.List()
+	if err != nil {
+		hlog.Errorf("scrub: failed to list uploads: %v", err)
+		return 0
+	}
+
+	corrupt := 0
+	for _, file := range files {
+		status, sum, err := verifyFile(file.Name)
+		if err == errNotFound {
+			continue // deleted since the listing
+		}
+		if err != nil {
+			hlog.Warnf("scrub: failed to verify %s: %v", file.Name, err)
+			continue
+		}
+		if status == integrityCorrupt {
+			hlog.Errorf("scrub: %s is corrupt (sha256 now %s)", file.Name, sum)
+			corrupt++
+		}
+	}
+	return corrupt
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/h2non/bimg"
+)
+
+// stripJPEGMetadata removes metadata segments from a JPEG without decoding
+// it: EXIF and XMP (APP1), the other application segments and comments. The
+// JFIF header (APP0), ICC profile (APP2) and Adobe colour transform (APP14)
+// are kept because decoders need them to reproduce the image's colours.
+// It returns false when the data can't be walked, so the caller re-encodes.
+func stripJPEGMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, false
+	}
+	var out bytes.Buffer
+	out.Grow(len(data))
+	out.Write(data[:2])
+
+	i := 2
+	for {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, false
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			i++ // fill byte
+			continue
+		}
+		if marker == 0xDA {
+			out.Write(data[i:]) // the scan and everything after it is copied as is
+			return out.Bytes(), true
+		}
+		if marker == 0xD9 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			return nil, false // no image data before the end, or unexpected standalone marker
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, false
+		}
+		isMetadata := (marker >= 0xE1 && marker <= 0xEF && marker != 0xE2 && marker != 0xEE) || marker == 0xFE
+		if !isMetadata {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+}
+
+// isUprightJPEG reports whether a JPEG needs no EXIF rotation to display
+// correctly, so dropping its EXIF orientation tag doesn't change how it looks
+func isUprightJPEG(img *bimg.Image) bool {
+	meta, err := img.Metadata()
+	return err == nil && meta.Orientation <= 1
+}
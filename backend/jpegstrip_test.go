@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+// withSegment inserts a JPEG marker segment right after SOI
+func withSegment(data []byte, marker byte, payload string) []byte {
+	n := len(payload) + 2
+	segment := append([]byte{0xFF, marker, byte(n >> 8), byte(n)}, payload...)
+	out := append([]byte(nil), data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	plain := testJPEG(t, 32, 32)
+	data := withSegment(plain, 0xE1, "Exif\x00\x00GPS would be here")
+	data = withSegment(data, 0xE2, "ICC_PROFILE\x00 profile")
+	data = withSegment(data, 0xFE, "a comment")
+
+	stripped, ok := stripJPEGMetadata(data)
+	if !ok {
+		t.Fatal("stripJPEGMetadata failed on a valid JPEG")
+	}
+	if bytes.Contains(stripped, []byte("Exif")) || bytes.Contains(stripped, []byte("a comment")) {
+		t.Error("EXIF or comment kept")
+	}
+	if !bytes.Contains(stripped, []byte("ICC_PROFILE")) {
+		t.Error("ICC profile dropped")
+	}
+	if err := validateImageData(stripped); err != nil {
+		t.Errorf("stripped JPEG is invalid: %v", err)
+	}
+
+	if _, ok := stripJPEGMetadata([]byte("not a jpeg")); ok {
+		t.Error("stripJPEGMetadata accepted non-JPEG data")
+	}
+}
+
+// BenchmarkSmallJPEGMetadata compares the fast path for small upright JPEGs,
+// which drops metadata segments without decoding, with the full decode and
+// re-encode it replaces
+func BenchmarkSmallJPEGMetadata(b *testing.B) {
+	data := withSegment(testJPEG(b, 640, 480), 0xE1, "Exif\x00\x00"+string(make([]byte, 4096)))
+
+	b.Run("strip-segments", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, ok := stripJPEGMetadata(data); !ok {
+				b.Fatal("strip failed")
+			}
+		}
+	})
+	b.Run("re-encode", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := bimg.NewImage(data).Process(bimg.Options{Type: bimg.JPEG, Quality: 80, StripMetadata: true}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// ipLimiter caps how many requests each client IP may have in flight
+type ipLimiter struct {
+	mu     sync.Mutex
+	max    int
+	active map[string]int
+}
+
+// newIPLimiter creates a limiter allowing max in-flight requests per IP
+func newIPLimiter(max int) *ipLimiter {
+	return &ipLimiter{max: max, active: make(map[string]int)}
+}
+
+// acquire takes a slot for ip, reporting false when it has none left
+func (l *ipLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip] >= l.max {
+		return false
+	}
+	l.active[ip]++
+	return true
+}
+
+// release frees a slot taken by acquire, forgetting IPs with none in use
+func (l *ipLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip]--; l.active[ip] <= 0 {
+		delete(l.active, ip)
+	}
+}
+
+// Middleware rejects a request with 429 while its client already has the
+// maximum number of requests in flight. The slot is released however the
+// handler ends, including panics and clients that disconnect midway, since
+// the handler always runs to completion.
+func (l *ipLimiter) Middleware(ctx context.Context, c *app.RequestContext) {
+	ip := c.ClientIP()
+	if !l.acquire(ip) {
+		respond(c, consts.StatusTooManyRequests, map[string]interface{}{
+			"error": "Too many concurrent uploads from this address",
+		})
+		c.Abort()
+		return
+	}
+	defer l.release(ip)
+	c.Next(ctx)
+}
+
+// globalLimiter caps the requests in flight across all clients. Requests to
+// the exempt routes, the health probes, always get through, so an
+// orchestrator doesn't restart an instance that is merely busy.
+type globalLimiter struct {
+	slots  chan struct{}
+	exempt map[string]bool
+}
+
+// newGlobalLimiter creates a limiter allowing max requests in flight, apart
+// from those to the exempt route paths
+func newGlobalLimiter(max int, exempt ...string) *globalLimiter {
+	l := &globalLimiter{slots: make(chan struct{}, max), exempt: make(map[string]bool)}
+	for _, path := range exempt {
+		l.exempt[path] = true
+	}
+	return l
+}
+
+// Middleware rejects a request with 503 and Retry-After while the maximum
+// number of requests is in flight. It doesn't queue: a saturated instance
+// sheds load at once instead of piling up waiting connections.
+func (l *globalLimiter) Middleware(ctx context.Context, c *app.RequestContext) {
+	if l.exempt[c.FullPath()] {
+		c.Next(ctx)
+		return
+	}
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		c.Header("Retry-After", "1")
+		respond(c, consts.StatusServiceUnavailable, map[string]interface{}{
+			"error": "Server is busy, try again shortly",
+		})
+		c.Abort()
+		return
+	}
+	defer func() { <-l.slots }()
+	c.Next(ctx)
+}
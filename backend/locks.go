@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// keyedMutex provides a mutex per key, created on demand and dropped once
+// no goroutine holds or waits for it
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the mutex for one key and the number of goroutines using it
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// newKeyedMutex creates an empty keyed mutex
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*keyedLock)}
+}
+
+// Lock blocks until the lock for key is held and returns the function releasing it
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		k.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// LockAll holds the locks for every key, taken in sorted order so callers
+// locking overlapping sets can't deadlock, and returns the function
+// releasing them
+func (k *keyedMutex) LockAll(keys ...string) func() {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	unlocks := make([]func(), 0, len(sorted))
+	for i, key := range sorted {
+		if i > 0 && key == sorted[i-1] {
+			continue
+		}
+		unlocks = append(unlocks, k.Lock(key))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	k := newKeyedMutex()
+	var inside, overlaps int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := k.Lock("same")
+			if atomic.AddInt32(&inside, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			atomic.AddInt32(&inside, -1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if overlaps != 0 {
+		t.Errorf("%d holders overlapped", overlaps)
+	}
+	if len(k.locks) != 0 {
+		t.Errorf("%d locks left behind after release", len(k.locks))
+	}
+
+	// Different keys don't block each other
+	unlockA := k.Lock("a")
+	unlockB := k.Lock("b")
+	unlockB()
+	unlockA()
+}
+
+func TestKeyedMutexLockAll(t *testing.T) {
+	k := newKeyedMutex()
+	sets := [][]string{{"a", "b", "c"}, {"c", "b", "a"}, {"b", "a", "b"}}
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(keys []string) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				k.LockAll(keys...)()
+			}
+		}(sets[i%len(sets)])
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("LockAll deadlocked on overlapping key sets")
+	}
+	if len(k.locks) != 0 {
+		t.Errorf("%d locks left behind after release", len(k.locks))
+	}
+}
+
+func TestConcurrentContentHashUploads(t *testing.T) {
+	mem := setupTestServer(t, map[string]string{
+		"FILENAME_SCHEME":    "content-hash",
+		"PROCESSING_WORKERS": "4",
+	})
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+	data := testPNG(t, 64, 64, 255)
+
+	const uploads = 20
+	var wg sync.WaitGroup
+	results := make([]map[string]interface{}, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := postImage(engine, "/upload", "same.png", data)
+			if w.Code != consts.StatusOK {
+				t.Errorf("upload %d: status %d: %s", i, w.Code, w.Body.String())
+				return
+			}
+			results[i] = decodeJSON(t, w)
+		}(i)
+	}
+	wg.Wait()
+
+	names := mem.Names()
+	if len(names) != 1 {
+		t.Fatalf("stored files = %v, want exactly one", names)
+	}
+	stored, _ := mem.Read(names[0])
+	sum := sha256.Sum256(stored)
+	if !strings.HasPrefix(names[0], hex.EncodeToString(sum[:])) {
+		t.Errorf("stored file %s doesn't match its content hash", names[0])
+	}
+	if err := validateImageData(stored); err != nil {
+		t.Errorf("stored file is not intact: %v", err)
+	}
+	deduplicated := 0
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		if result["filename"] != names[0] {
+			t.Errorf("an upload returned %v, want %s", result["filename"], names[0])
+		}
+		if result["deduplicated"] != nil {
+			deduplicated++
+		}
+	}
+	if deduplicated != uploads-1 {
+		t.Errorf("%d uploads deduplicated, want %d", deduplicated, uploads-1)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logLevels maps LOG_LEVEL names to hlog levels
+var logLevels = map[string]hlog.Level{
+	"trace":  hlog.LevelTrace,
+	"debug":  hlog.LevelDebug,
+	"info":   hlog.LevelInfo,
+	"notice": hlog.LevelNotice,
+	"warn":   hlog.LevelWarn,
+	"error":  hlog.LevelError,
+	"fatal":  hlog.LevelFatal,
+}
+
+// parseLogLevel parses a LOG_LEVEL name, case-insensitively
+func parseLogLevel(s string) (hlog.Level, error) {
+	level, ok := logLevels[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return 0, fmt.Errorf("invalid LOG_LEVEL: %q (expected trace, debug, info, notice, warn, error or fatal)", s)
+	}
+	return level, nil
+}
+
+// setupLogging points hlog, which both this service and Hertz log through,
+// at stdout in the configured format and level
+func setupLogging(format string, level hlog.Level) {
+	if format == "json" {
+		hlog.SetLogger(newJSONLogger(os.Stdout))
+	}
+	hlog.SetOutput(os.Stdout)
+	hlog.SetLevel(level)
+}
+
+// jsonLogger is an hlog.FullLogger writing one JSON object per line, e.g.
+// {"time":"...","level":"warn","msg":"...","trace_id":"..."}. Messages
+// logged with a context carry its trace and span IDs when it is traced.
+type jsonLogger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level hlog.Level
+}
+
+// jsonLogEntry is one line of JSON log output
+type jsonLogEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"msg"`
+	TraceID string `json:"trace_id,omitempty"`
+	SpanID  string `json:"span_id,omitempty"`
+}
+
+// jsonLevelNames names the levels in JSON output
+var jsonLevelNames = map[hlog.Level]string{
+	hlog.LevelTrace:  "trace",
+	hlog.LevelDebug:  "debug",
+	hlog.LevelInfo:   "info",
+	hlog.LevelNotice: "notice",
+	hlog.LevelWarn:   "warn",
+	hlog.LevelError:  "error",
+	hlog.LevelFatal:  "fatal",
+}
+
+// newJSONLogger creates a JSON logger writing to out at info level
+func newJSONLogger(out io.Writer) *jsonLogger {
+	return &jsonLogger{out: out, level: hlog.LevelInfo}
+}
+
+// write logs one entry unless its level is filtered out; fatal entries exit
+// like hlog's default logger does
+func (l *jsonLogger) write(ctx context.Context, level hlog.Level, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+	entry := jsonLogEntry{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level:   jsonLevelNames[level],
+		Message: msg,
+	}
+	if ctx != nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			entry.TraceID, entry.SpanID = sc.TraceID().String(), sc.SpanID().String()
+		}
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("failed to encode log entry: %v", err)
+		return
+	}
+	l.out.Write(append(line, '\n'))
+	if level == hlog.LevelFatal {
+		os.Exit(1)
+	}
+}
+
+func (l *jsonLogger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = w
+}
+
+func (l *jsonLogger) SetLevel(level hlog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+func (l *jsonLogger) Trace(v ...interface{})  { l.write(nil, hlog.LevelTrace, fmt.Sprint(v...)) }
+func (l *jsonLogger) Debug(v ...interface{})  { l.write(nil, hlog.LevelDebug, fmt.Sprint(v...)) }
+func (l *jsonLogger) Info(v ...interface{})   { l.write(nil, hlog.LevelInfo, fmt.Sprint(v...)) }
+func (l *jsonLogger) Notice(v ...interface{}) { l.write(nil, hlog.LevelNotice, fmt.Sprint(v...)) }
+func (l *jsonLogger) Warn(v ...interface{})   { l.write(nil, hlog.LevelWarn, fmt.Sprint(v...)) }
+func (l *jsonLogger) Error(v ...interface{})  { l.write(nil, hlog.LevelError, fmt.Sprint(v...)) }
+func (l *jsonLogger) Fatal(v ...interface{})  { l.write(nil, hlog.LevelFatal, fmt.Sprint(v...)) }
+
+func (l *jsonLogger) Tracef(format string, v ...interface{}) {
+	l.write(nil, hlog.LevelTrace, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) Debugf(format string, v ...interface{}) {
+	l.write(nil, hlog.LevelDebug, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) Infof(format string, v ...interface{}) {
+	l.write(nil, hlog.LevelInfo, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) Noticef(format string, v ...interface{}) {
+	l.write(nil, hlog.LevelNotice, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) Warnf(format string, v ...interface{}) {
+	l.write(nil, hlog.LevelWarn, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) Errorf(format string, v ...interface{}) {
+	l.write(nil, hlog.LevelError, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) Fatalf(format string, v ...interface{}) {
+	l.write(nil, hlog.LevelFatal, fmt.Sprintf(format, v...))
+}
+
+func (l *jsonLogger) CtxTracef(ctx context.Context, format string, v ...interface{}) {
+	l.write(ctx, hlog.LevelTrace, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) CtxDebugf(ctx context.Context, format string, v ...interface{}) {
+	l.write(ctx, hlog.LevelDebug, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) CtxInfof(ctx context.Context, format string, v ...interface{}) {
+	l.write(ctx, hlog.LevelInfo, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) CtxNoticef(ctx context.Context, format string, v ...interface{}) {
+	l.write(ctx, hlog.LevelNotice, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) CtxWarnf(ctx context.Context, format string, v ...interface{}) {
+	l.write(ctx, hlog.LevelWarn, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) CtxErrorf(ctx context.Context, format string, v ...interface{}) {
+	l.write(ctx, hlog.LevelError, fmt.Sprintf(format, v...))
+}
+func (l *jsonLogger) CtxFatalf(ctx context.Context, format string, v ...interface{}) {
+	l.write(ctx, hlog.LevelFatal, fmt.Sprintf(format, v...))
+}
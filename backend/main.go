@@ -1,18 +1,22 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
-	"strings"
-	"time"
 	"github.com/cloudwego/hertz/pkg/app"
 	"github.com/cloudwego/hertz/pkg/app/server"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
 	"github.com/cloudwego/hertz/pkg/protocol/consts"
 	"github.com/h2non/bimg"
+	h2config "github.com/hertz-contrib/http2/config"
+	"github.com/hertz-contrib/http2/factory"
+	"mime/multipart"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // isImageFile checks if the file has an image extension
@@ -29,150 +33,548 @@ func isImageFile(filename string) bool {
 	return validExtensions[ext]
 }
 
-// compressImage compresses the image to ensure it's under 1MB
-func compressImage(imageData []byte) ([]byte, error) {
+// compressImage compresses the image to ensure it's under the target size
+// selected by the configured compression tiers (1MB by default), applying
+// the requested resize and output format
+func compressImage(ctx context.Context, imageData []byte, opts uploadOptions) ([]byte, error) {
 	img := bimg.NewImage(imageData)
-	
-	// Get original size in bytes
+
+	// Get original size in bytes and dimensions
 	size := len(imageData)
-	maxSize := 1024 * 1024 // 1MB in bytes
-	
-	if size <= maxSize {
-		return imageData, nil // No compression needed
+	width, height := 0, 0
+	if dims, err := img.Size(); err == nil {
+		width, height = dims.Width, dims.Height
 	}
-	
-	// Start with 80% quality
-	quality := 80
-	
-	// Try compression with decreasing quality until size is under 1MB
-	for quality >= 20 {
-		options := bimg.Options{
-			Quality: quality,
+	maxSize := capTarget(compressionTarget(cfg.CompressionTiers, size, width, height), opts)
+
+	base := bimg.Options{Type: opts.Format, NoAutoRotate: opts.NoAutoRotate}
+	output := opts.Format
+	if output == bimg.UNKNOWN {
+		output = bimg.DetermineImageType(imageData)
+	}
+	applyResize(&base, opts, img, output)
+
+	// CMYK images from print workflows come out with inverted colours when
+	// processed naively, so they are always converted to sRGB through their
+	// ICC profile, and the heavy embedded profile is stripped afterward. Under
+	// ICC_PROFILE_POLICY=keep-srgb the compact sRGB profile is kept instead,
+	// and with it the rest of the metadata.
+	cmyk := isCMYK(img)
+	if cmyk {
+		base.Interpretation = bimg.InterpretationSRGB
+		base.OutputICC = "srgb" // libvips built-in sRGB profile
+		base.StripMetadata = cfg.ICCProfilePolicy != iccKeepSRGB
+	}
+	// Other embedded profiles are converted or dropped as ICC_PROFILE_POLICY says
+	reprofiling := !cmyk && applyProfilePolicy(&base, img)
+	// 16-bit images (scientific cameras, some PNG/TIFF exports) are cast to
+	// 8 bits per channel, keeping grayscale as grayscale
+	normalizing := false
+	if cfg.NormalizeBitDepth {
+		if interpretation, ok := eightBitInterpretation(img); ok {
+			base.Interpretation = interpretation
+			normalizing = true
 		}
-		
+	}
+	// A copyright notice replaces the original metadata, so it is stripped
+	// here and the notice added after encoding
+	if cfg.CopyrightText != "" {
+		base.StripMetadata = true
+	}
+	// Under ALPHA_POLICY=flatten transparent images are made opaque, onto
+	// the bg colour when one was given, including black
+	flattening := cfg.AlphaPolicy == alphaFlatten && hasAlpha(img)
+	if flattening {
+		base.Background = flattenColor(cfg.AlphaBackground)
+		if opts.Background != nil {
+			base.Background = flattenColor(*opts.Background)
+		}
+	}
+
+	// A fixed quality (the quality sweep) skips the size target entirely
+	if opts.Quality > 0 {
+		base.Quality = opts.Quality
+		base.Lossless = opts.Lossless && output == bimg.WEBP
+		return img.Process(base)
+	}
+
+	converting := output != bimg.DetermineImageType(imageData)
+	resizing := opts.Width > 0 || opts.Height > 0
+	if size <= maxSize && !cmyk && !converting && !resizing && !flattening && !normalizing && !reprofiling {
+		if !base.StripMetadata {
+			return imageData, nil // No compression needed
+		}
+		// Small upright JPEGs only need their metadata segments dropped,
+		// which is much cheaper than decoding and re-encoding them
+		if output == bimg.JPEG && isUprightJPEG(img) {
+			if stripped, ok := stripJPEGMetadata(imageData); ok {
+				return stripped, nil
+			}
+		}
+	}
+
+	// Lossless WebP keeps sharp edges (diagrams, screenshots) artifact-free.
+	// It is kept unless it overshoots the target by more than
+	// LOSSLESS_MAX_OVERSIZE percent, in which case lossy encoding takes over
+	if opts.Lossless && output == bimg.WEBP {
+		options := base
+		options.Lossless = true
 		compressed, err := img.Process(options)
 		if err != nil {
 			return nil, fmt.Errorf("compression failed: %v", err)
 		}
-		
-		if len(compressed) <= maxSize {
+		if len(compressed) <= maxSize+maxSize*cfg.LosslessMaxOversize/100 {
 			return compressed, nil
 		}
-		
-		quality -= 10
 	}
-	
+
+	// Start with 80% quality, or the API key's default
+	quality := 80
+	if opts.DefaultQuality > 0 {
+		quality = opts.DefaultQuality
+	}
+
+	// Look for the highest quality that meets the target, re-encoding at
+	// most MAX_QUALITY_ATTEMPTS times
+	encode := func(quality int) ([]byte, error) {
+		if ctx.Err() != nil {
+			return nil, processingStopped(ctx, "while searching for a quality")
+		}
+		options := base
+		options.Quality = quality
+		compressed, err := img.Process(options)
+		if err != nil {
+			return nil, fmt.Errorf("compression failed: %v", err)
+		}
+		return compressed, nil
+	}
+	compressed, smallest, capped, err := searchQuality(encode, quality, maxSize)
+	if err != nil || compressed != nil {
+		return compressed, err
+	}
+	if capped {
+		hlog.Infof("quality attempts capped at %d for a %d byte image, reducing dimensions", cfg.MaxQualityAttempts, size)
+	}
+
 	// If still too large, try reducing dimensions
-	options := bimg.Options{
-		Quality: 70,
-		Width:   800, // Reduce width to 800px max
+	options := base
+	options.Quality = 70
+	if options.Width > 800 {
+		options.Height = options.Height * 800 / options.Width // keep the requested box's shape
+	}
+	if options.Width == 0 || options.Width > 800 {
+		options.Width = 800 // Reduce width to 800px max
+	}
+
+	if ctx.Err() != nil {
+		return nil, processingStopped(ctx, "before reducing dimensions")
+	}
+	compressed, err = img.Process(options)
+	if err != nil || len(compressed) <= maxSize {
+		return compressed, err
+	}
+	if smallest == nil || len(compressed) < len(smallest) {
+		smallest = compressed
+	}
+	// Nothing met the target: reject the image, or keep the smallest attempt
+	// under ON_SIZE_EXCEEDED=store-anyway
+	if cfg.OnSizeExceeded == sizeExceededReject {
+		return nil, &httpError{consts.StatusUnprocessableEntity, fmt.Sprintf("Image can't be compressed under the %d byte target: the smallest achievable size is %d bytes", maxSize, len(smallest))}
+	}
+	return smallest, nil
+}
+
+// eightBitInterpretation returns the 8-bit colour space to convert a 16-bit
+// image to, or false when the image isn't 16-bit
+func eightBitInterpretation(img *bimg.Image) (bimg.Interpretation, bool) {
+	interpretation, err := img.Interpretation()
+	if err != nil {
+		return 0, false
+	}
+	switch interpretation {
+	case bimg.InterpretationRGB16:
+		return bimg.InterpretationSRGB, true
+	case bimg.InterpretationGREY16:
+		return bimg.InterpretationBW, true
 	}
-	
-	return img.Process(options)
+	return 0, false
+}
+
+// isCMYK reports whether an image is stored in the CMYK colour space
+func isCMYK(img *bimg.Image) bool {
+	interpretation, err := img.Interpretation()
+	return err == nil && interpretation == bimg.InterpretationCMYK
 }
 
 // handleImageUpload handles the image upload request
 func handleImageUpload(ctx context.Context, c *app.RequestContext) {
-	fileHeader, err := c.FormFile("image")
-	if err != nil {
-		c.JSON(consts.StatusBadRequest, map[string]interface{}{
-			"error": "Failed to get image file from request",
-		})
-		return
+	var (
+		name   string
+		data   []byte
+		result map[string]interface{}
+		status = consts.StatusOK
+		err    error
+	)
+	// Record every attempt, successful or not, in the audit log
+	defer func() {
+		entry := newAuditEntry(c.ClientIP(), "upload", data, result, err)
+		entry.OriginalName = name
+		if key, ok := requestAPIKey(c); ok {
+			entry.APIKey = key.Name
+		}
+		if err == nil {
+			entry.Status = status
+		}
+		audit.Record(entry)
+	}()
+
+	// Collect every problem with the file, its content and the parameters
+	// before answering, so the client can fix them all at once
+	var errs validationErrors
+	name, data, readErr := readUploadedImage(ctx, c)
+	errs.add(readErr)
+	var validation time.Duration
+	if readErr == nil {
+		var invalid error
+		validation, invalid = validateImage(ctx, data)
+		errs.add(invalid)
 	}
-	if !isImageFile(fileHeader.Filename) {
-		c.JSON(consts.StatusBadRequest, map[string]interface{}{
-			"error": "Uploaded file is not a valid image",
-		})
+	opts, optsErr := parseUploadOptions(c)
+	errs.add(optsErr)
+	iconSet, iconSetErr := parseIconSetOptions(c, &opts)
+	errs.add(iconSetErr)
+	if iconSet && iconSetErr == nil && readErr == nil {
+		errs.add(checkIconSource(data, opts))
+	}
+	dualFormat, dualFormatErr := parseDualFormatOptions(c, opts)
+	errs.add(dualFormatErr)
+	if iconSet && dualFormat {
+		errs.add(&httpError{consts.StatusBadRequest, "dual_format can't be combined with iconset"})
+	}
+	if err = errs.err(); err != nil {
+		respond(c, errorStatus(err), errorResponse(err))
 		return
 	}
 
-	// Open the uploaded file
-	file, err := fileHeader.Open()
+	// ?iconset=true stores every standard icon size instead of one image, and
+	// ?dual_format=true a WebP and a JPEG version
+	process, message := processUpload, "Image uploaded and compressed successfully"
+	switch {
+	case iconSet:
+		process, message = processIconSet, "Icon set generated successfully"
+	case dualFormat:
+		process, message = processDualFormat, "Image uploaded as WebP and JPEG successfully"
+	}
+	result, timing, err := process(ctx, name, data, opts)
+	timing.Validation = validation
+	timing.setHeaders(c)
 	if err != nil {
-		c.JSON(consts.StatusInternalServerError, map[string]interface{}{
-			"error": "Failed to open uploaded file",
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
 		})
 		return
 	}
-	defer file.Close()
 
-	// Read the file into memory
-	buffer := bytes.NewBuffer(nil)
-	if _, err := io.Copy(buffer, file); err != nil {
-		c.JSON(consts.StatusInternalServerError, map[string]interface{}{
-			"error": "Failed to read uploaded file",
-		})
-		return
+	// Return the file information, as 201 Created with its URL when
+	// UPLOAD_CREATED_STATUS is on and the file is new
+	result["message"] = message
+	if cfg.UploadCreatedStatus {
+		if url, ok := result["url"].(string); ok {
+			c.Header("Location", url)
+		}
+		if result["deduplicated"] == nil {
+			status = consts.StatusCreated
+		}
+	}
+	respond(c, status, result)
+}
+
+// readUploadedImage reads the image form field into memory
+func readUploadedImage(ctx context.Context, c *app.RequestContext) (string, []byte, error) {
+	var err error
+	if err := checkContentLength(c); err != nil {
+		return "", nil, err
+	}
+	if err := checkFileCount(c); err != nil {
+		return "", nil, err
+	}
+	// Take the first of the accepted form fields that holds a file
+	var fileHeader *multipart.FileHeader
+	for _, field := range cfg.UploadFieldNames {
+		if fileHeader, err = c.FormFile(field); err == nil {
+			break
+		}
+	}
+	if fileHeader == nil {
+		return "", nil, &httpError{consts.StatusBadRequest, fmt.Sprintf("Failed to get image file from request: expected a file in form field %s", strings.Join(cfg.UploadFieldNames, ", "))}
+	}
+	// Files without an extension are accepted and identified by their content
+	if filepath.Ext(fileHeader.Filename) != "" && !isImageFile(fileHeader.Filename) {
+		return "", nil, &httpError{consts.StatusBadRequest, "Uploaded file is not a valid image"}
 	}
 
-	// Create uploads directory if it doesn't exist
-	uploadsDir, err := filepath.Abs("uploads")
+	// Read the file into memory, retrying transient failures
+	data, err := readFormFile(ctx, fileHeader)
 	if err != nil {
-		c.JSON(consts.StatusInternalServerError, map[string]interface{}{
-			"error": "Failed to get absolute path for uploads directory",
-		})
-		return
+		return "", nil, err
 	}
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		c.JSON(consts.StatusInternalServerError, map[string]interface{}{
-			"error": "Failed to create uploads directory",
-		})
-		return
+	// Chunked requests are only held to MAX_UPLOAD_SIZE by the server, so an
+	// API key's smaller limit is checked again here
+	if limit := uploadSizeLimit(c); len(data) > limit {
+		return "", nil, &httpError{consts.StatusRequestEntityTooLarge, fmt.Sprintf("Uploaded file exceeds the %d byte limit", limit)}
+	}
+	return fileHeader.Filename, data, nil
+}
+
+// checkContentLength rejects a request whose declared Content-Length is over
+// MAX_UPLOAD_SIZE before the multipart body is parsed. Chunked requests
+// declare no length and are held to the same limit by the server's body cap.
+func checkContentLength(c *app.RequestContext) error {
+	if limit := uploadSizeLimit(c); c.Request.Header.ContentLength() > limit {
+		return &httpError{consts.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the %d byte limit", limit)}
 	}
+	return nil
+}
 
-	// Compress the image
-	compressed, err := compressImage(buffer.Bytes())
+// checkFileCount rejects multipart requests carrying more than
+// MAX_FILES_PER_REQUEST files in total, before any of them is processed
+func checkFileCount(c *app.RequestContext) error {
+	form, err := c.MultipartForm()
 	if err != nil {
-		c.JSON(consts.StatusInternalServerError, map[string]interface{}{
-			"error": fmt.Sprintf("Failed to compress image: %v", err),
-		})
-		return
+		return nil // reported as a missing image by the caller
+	}
+	count := 0
+	for _, files := range form.File {
+		count += len(files)
 	}
+	if count > cfg.MaxFilesPerRequest {
+		return &httpError{consts.StatusBadRequest, fmt.Sprintf("Too many files: at most %d per request", cfg.MaxFilesPerRequest)}
+	}
+	return nil
+}
 
-	// Generate unique filename
-	timestamp := time.Now().UnixNano()
-	ext := filepath.Ext(fileHeader.Filename)
-	filename := fmt.Sprintf("%d%s", timestamp, ext)
-	filePath := filepath.Join(uploadsDir, filename)
+// parseUploadOptions reads the processing query parameters shared by /upload
+// and /process. Every invalid parameter is reported, not just the first.
+func parseUploadOptions(c *app.RequestContext) (uploadOptions, error) {
+	opts := defaultUploadOptions()
+	var errs validationErrors
+	key, _ := requestAPIKey(c)
+	opts.DefaultQuality = key.DefaultQuality
+	opts.Namespace = key.Namespace
+	opts.AllowedFormats = key.AllowedFormats
 
-	// Save the compressed image
-	if err := os.WriteFile(filePath, compressed, 0644); err != nil {
-		c.JSON(consts.StatusInternalServerError, map[string]interface{}{
-			"error": "Failed to save compressed image",
-		})
-		return
+	// Parse the optional per-upload expiry
+	if v := c.Query("expires_in"); v != "" {
+		expiresIn, err := parseExpiresIn(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, err.Error()})
+		}
+		opts.ExpiresIn = expiresIn
 	}
 
-	// Return the file information
-	c.JSON(consts.StatusOK, map[string]interface{}{
-		"message": "Image uploaded and compressed successfully",
-		"original_size": fileHeader.Size,
-		"compressed_size": len(compressed),
-		"filename": filename,
-		"url": func() string {
-			publicURL := os.Getenv("PUBLIC_URL")
-			fmt.Printf("Debug: PUBLIC_URL=%s\n", publicURL)
-			if publicURL == "" {
-				publicURL = "http://localhost:8888"
-			}
-			return fmt.Sprintf("%s/uploads/%s", strings.TrimRight(publicURL, "/"), filename)
-		}(),
-	})
+	// Parse the optional output format
+	if v := c.Query("format"); v != "" {
+		if cfg.ForceOutputFormat != bimg.UNKNOWN && !cfg.AllowFormatOverride {
+			errs.add(&httpError{consts.StatusBadRequest, fmt.Sprintf("format can't be chosen: all uploads are stored as %s", bimg.ImageTypeName(cfg.ForceOutputFormat))})
+		}
+		format, err := parseOutputFormat(v)
+		switch {
+		case err != nil:
+			errs.add(&httpError{consts.StatusBadRequest, err.Error()})
+		case !canSave(format):
+			errs.add(unsupportedFormat(format))
+		case key.AllowedFormats != nil && !key.AllowedFormats[format]:
+			errs.add(&httpError{consts.StatusForbidden, fmt.Sprintf("format %s is not allowed for this API key", bimg.ImageTypeName(format))})
+		}
+		opts.Format = format
+	}
+
+	// Parse the optional resize box
+	if v := c.Query("width"); v != "" {
+		width, err := parseDimension("width", v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, err.Error()})
+		}
+		opts.Width = width
+	}
+	if v := c.Query("height"); v != "" {
+		height, err := parseDimension("height", v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, err.Error()})
+		}
+		opts.Height = height
+	}
+	if v := c.Query("fit"); v != "" {
+		fit, err := parseFit(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, err.Error()})
+		}
+		opts.Fit = fit
+		if opts.Width == 0 || opts.Height == 0 {
+			errs.add(&httpError{consts.StatusBadRequest, "fit requires both width and height"})
+		}
+	}
+	if v := c.Query("bg"); v != "" {
+		color, err := parseHexColor(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, "bg must be a hex colour such as ffffff"})
+		}
+		opts.Background = &color
+	}
+
+	// Parse the optional auto-rotation override
+	if v := c.Query("autorotate"); v != "" {
+		autoRotate, err := strconv.ParseBool(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, "autorotate must be true or false"})
+		}
+		opts.NoAutoRotate = !autoRotate
+	}
+
+	// Parse the optional hash the stored image must match
+	if v := string(c.GetHeader("X-Expected-SHA256")); v != "" {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if _, err := hex.DecodeString(v); err != nil || len(v) != sha256.Size*2 {
+			errs.add(&httpError{consts.StatusBadRequest, "X-Expected-SHA256 must be 64 hex digits"})
+		}
+		opts.ExpectedSHA256 = v
+	}
+
+	// Parse the optional lossless WebP switch
+	if v := c.Query("lossless"); v != "" {
+		lossless, err := strconv.ParseBool(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, "lossless must be true or false"})
+		}
+		opts.Lossless = lossless
+	}
+
+	// Parse the optional border trim switch
+	if v := c.Query("trim"); v != "" {
+		trim, err := strconv.ParseBool(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, "trim must be true or false"})
+		}
+		opts.Trim = trim
+	}
+
+	// Parse the optional document deskew switch
+	if v := c.Query("autostraighten"); v != "" {
+		straighten, err := strconv.ParseBool(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, "autostraighten must be true or false"})
+		}
+		opts.Straighten = straighten
+	}
+
+	// Parse the optional output resolution
+	if v := c.Query("dpi"); v != "" {
+		dpi, err := parseDPI(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, err.Error()})
+		}
+		opts.DPI = dpi
+	}
+
+	// Parse the optional per-upload size budget
+	if v := c.Query("max_bytes"); v != "" {
+		maxBytes, err := parseMaxBytes(v)
+		if err != nil {
+			errs.add(&httpError{consts.StatusBadRequest, err.Error()})
+		}
+		opts.MaxBytes = maxBytes
+	}
+	return opts, errs.err()
 }
 
 func main() {
+	var err error
+	if cfg, err = loadConfig(); err != nil {
+		panic(err)
+	}
+	setupLogging(cfg.LogFormat, cfg.LogLevel)
+	if cfg.DryRun {
+		hlog.Warn("DRY_RUN is enabled: uploads are processed but not stored")
+	}
+	if err := setupTempDir(cfg.TempDir); err != nil {
+		panic(err)
+	}
+	configureVips()
+	probeFormats()
+	if cfg.PHashBlocklistFile != "" {
+		if blocklist, err = loadBlocklist(cfg.PHashBlocklistFile); err != nil {
+			panic(fmt.Errorf("invalid PHASH_BLOCKLIST_FILE: %v", err))
+		}
+		if cfg.PHashBlocklistPollInterval > 0 {
+			blocklist.watch(cfg.PHashBlocklistPollInterval)
+		}
+	}
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	if cfg.AuditLogFile != "" {
+		if audit, err = openAuditLog(cfg.AuditLogFile, cfg.AuditLogMaxSize, cfg.AuditLogBackups); err != nil {
+			panic(err)
+		}
+	}
+	publisher, err := newEventPublisher(cfg)
+	if err != nil {
+		panic(err)
+	}
+	events = startEventQueue(publisher, cfg.EventQueueSize)
+
 	h := server.Default(
 		server.WithHostPorts(":8888"),
-		server.WithMaxRequestBodySize(20*1024*1024), // Allow up to 20MB uploads
+		server.WithMaxRequestBodySize(cfg.MaxUploadSize),
+		server.WithReadTimeout(cfg.ReadTimeout),
+		server.WithWriteTimeout(cfg.WriteTimeout),
+		server.WithIdleTimeout(cfg.IdleTimeout),
+		server.WithKeepAlive(cfg.KeepAlive),
+		server.WithH2C(cfg.HTTP2),
 	)
+	if cfg.HTTP2 {
+		// Serve cleartext HTTP/2 (h2c) alongside HTTP/1.1
+		h.AddProtocol("h2", factory.NewServerFactory(
+			h2config.WithReadTimeout(cfg.ReadTimeout),
+			h2config.WithIdleTimeout(cfg.IdleTimeout),
+			h2config.WithDisableKeepAlive(!cfg.KeepAlive),
+			h2config.WithMaxConcurrentStreams(uint32(cfg.MaxConcurrentStreams)),
+		))
+	}
+
+	// Only believe forwarded client addresses from trusted proxies
+	h.SetClientIPFunc(clientIPFunc(cfg.TrustedProxies))
+
+	// Trace every request when an OTLP endpoint is configured, flushing the
+	// remaining spans on shutdown
+	h.Use(tracingMiddleware)
+	h.OnShutdown = append(h.OnShutdown, func(ctx context.Context) {
+		if err := shutdownTracing(ctx); err != nil {
+			hlog.Errorf("failed to flush traces: %v", err)
+		}
+	}, func(ctx context.Context) {
+		if err := events.Close(ctx); err != nil {
+			hlog.Errorf("failed to close event publisher: %v", err)
+		}
+	})
+
+	// Build returned URLs from each request's scheme and host when asked to
+	if cfg.PublicURLMode == publicURLRequestDerived {
+		h.Use(publicURLMiddleware)
+	}
+
+	// Compress JSON and XML responses; images are sent as stored
+	if cfg.ResponseCompression {
+		h.Use(compressionMiddleware)
+	}
 
 	// Setup CORS middleware
 	h.Use(func(ctx context.Context, c *app.RequestContext) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key")
+
 		if string(c.Method()) == "OPTIONS" {
 			c.AbortWithStatus(consts.StatusNoContent)
 			return
@@ -180,22 +582,146 @@ func main() {
 		c.Next(ctx)
 	})
 
-	// Basic health check endpoint
-	h.GET("/ping", func(ctx context.Context, c *app.RequestContext) {
-		c.JSON(consts.StatusOK, map[string]interface{}{
+	// Cap the requests in flight across all clients, except health probes
+	if cfg.MaxConcurrentRequests > 0 {
+		h.Use(newGlobalLimiter(cfg.MaxConcurrentRequests,
+			cfg.RoutePrefix+"/livez", cfg.RoutePrefix+"/healthz", cfg.RoutePrefix+"/ping").Middleware)
+	}
+
+	// Every endpoint lives under ROUTE_PREFIX, empty by default
+	routes := h.Group(cfg.RoutePrefix)
+
+	// Liveness (/ping, /livez) and readiness (/healthz) probes
+	routes.GET("/livez", handleLiveness)
+	routes.GET("/healthz", handleReadiness)
+	routes.GET("/stats", handleStats)
+	routes.GET("/ping", func(ctx context.Context, c *app.RequestContext) {
+		respond(c, consts.StatusOK, map[string]interface{}{
 			"message": "pong",
 		})
 	})
 
-	// Image upload endpoint
-	h.POST("/upload", handleImageUpload)
+	// Image upload endpoints
+	pool = newWorkerPool(cfg.ProcessingWorkers)
+	// Image-processing routes share the per-IP concurrency limit
+	processing := routes.Group("/")
+	if cfg.APIKeys != nil {
+		processing.Use(requireAPIKey)
+	}
+	if cfg.MaxConcurrentPerIP > 0 {
+		processing.Use(newIPLimiter(cfg.MaxConcurrentPerIP).Middleware)
+	}
+	// Routes that store new files are refused while disk space is short
+	processing.POST("/upload", requireAllowedOrigin, requireFreeDiskSpace, handleImageUpload)
+	processing.POST("/import", requireFreeDiskSpace, handleImport)
+	processing.POST("/import-zip", requireFreeDiskSpace, handleImportZip)
+	processing.POST("/process", handleProcess)
+	processing.POST("/detect", handleDetect)
+	processing.POST("/analyze/quality-sweep", handleQualitySweep)
+	processing.PUT("/uploads/:filename", requireFreeDiskSpace, handleReplaceUpload)
+	// Editing tags needs an API key too when keys are configured
+	tagging := routes.Group("/")
+	if cfg.APIKeys != nil {
+		tagging.Use(requireAPIKey)
+	}
+	tagging.PUT("/images/:filename/meta", handlePutImageMeta)
+	routes.GET("/images", handleListImages)
+	routes.GET("/images/:filename/meta", handleGetImageMeta)
+	routes.GET("/images/similar", handleSimilarImages)
+	routes.POST("/images/download-zip", handleDownloadZip)
+	routes.GET("/images/:filename/verify", handleVerifyFile)
+	routes.GET("/images/:filename/analyze", handleImageStats)
+	routes.GET("/images/:filename/info", handleImageInfo)
 
-	// Serve static files from uploads directory
 	uploadsPath, err := filepath.Abs("uploads")
 	if err != nil {
 		panic(err)
 	}
-	h.StaticFS("/uploads", &app.FS{Root: uploadsPath, PathRewrite: app.NewPathSlashesStripper(1)})
+	if store, err = newStorage(cfg.StorageBackend, uploadsPath); err != nil {
+		panic(err)
+	}
+	if cfg.StorageBackend == "disk" {
+		uploadsVolume = uploadsPath
+		metadataPath, err := filepath.Abs(cfg.MetadataDir)
+		if err != nil {
+			panic(err)
+		}
+		meta = newMetadataStore(metadataPath)
+		if phashes, err = loadPHashIndex(filepath.Join(metadataPath, "phash-index.json")); err != nil {
+			panic(err)
+		}
+		if shortIDs, err = loadShortIDIndex(filepath.Join(metadataPath, "short-ids.json")); err != nil {
+			panic(err)
+		}
+		if assets, err = loadAssetIndex(filepath.Join(metadataPath, "assets.json")); err != nil {
+			panic(err)
+		}
+	} else {
+		meta = newMetadataStore("")
+		phashes, _ = loadPHashIndex("")
+		shortIDs, _ = loadShortIDIndex("")
+		assets, _ = loadAssetIndex("")
+	}
+	startCleanup(cfg.CleanupInterval)
+	orphans = &orphanScanner{tempDir: cfg.TempDir}
+	if cfg.StorageBackend == "disk" {
+		orphans.uploadsDir = uploadsPath
+	}
+	if cfg.TempFileTTL > 0 {
+		startTempSweeper(cfg.CleanupInterval, cfg.TempFileTTL)
+	}
+	if cfg.ScrubInterval > 0 {
+		startScrubber(cfg.ScrubInterval)
+	}
+
+	// Serve uploaded files, straight from the uploads directory when stored on
+	// disk. /uploads/<name>/raw and ?raw=1 always return the stored bytes.
+	// With SERVE_STATIC=false another server serves them instead.
+	if cfg.ServeStatic {
+		serveFile := handleStoredFile
+		if cfg.StorageBackend == "disk" {
+			serveFile = newDiskFileHandler(uploadsPath)
+		}
+		serveUpload := func(ctx context.Context, c *app.RequestContext) {
+			setHeaders(c, cfg.UploadsHeaders)
+			if location, ok := canonicalUploadPath(c); ok {
+				c.Redirect(consts.StatusMovedPermanently, []byte(location))
+				return
+			}
+			if isRawRequest(c) {
+				handleRawFile(ctx, c)
+				return
+			}
+			serveFile(ctx, c)
+		}
+		routes.GET("/uploads/*filepath", serveUpload)
+		routes.HEAD("/uploads/*filepath", serveUpload)
+	}
+	if cfg.ShortIDs {
+		routes.GET("/s/:id", handleShortID)
+		routes.HEAD("/s/:id", handleShortID)
+	}
+	routes.DELETE("/uploads/:filename", handleDeleteUpload)
+	if cfg.Assets {
+		routes.GET("/assets/:id", handleGetAsset)
+		routes.DELETE("/assets/:id", handleDeleteAsset)
+	}
+	routes.POST("/commit", handleCommitUpload)
+
+	// Admin endpoints, only available with ADMIN_TOKEN set
+	if cfg.AdminToken != "" {
+		admin := routes.Group("/admin", requireAdminToken)
+		admin.GET("/orphans", handleListOrphans)
+		admin.POST("/orphans/cleanup", handleCleanupOrphans)
+		admin.POST("/blocklist/reload", handleReloadBlocklist)
+		admin.POST("/reprocess", handleStartReprocess)
+		admin.GET("/reprocess", handleReprocessStatus)
+		admin.DELETE("/reprocess", handleCancelReprocess)
+		// The configuration in effect, with secrets redacted
+		routes.GET("/config", requireAdminToken, handleGetConfig)
+	}
+
+	loadPlaceholder(cfg.MissingImagePlaceholder)
 
 	h.Spin()
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+// testCMYKJPEG converts an RGB JPEG to a CMYK one with libvips, skipping the
+// test when this build can't write CMYK
+func testCMYKJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	cmyk, err := bimg.NewImage(testJPEG(t, w, h)).Process(bimg.Options{
+		Type:           bimg.JPEG,
+		Interpretation: bimg.InterpretationCMYK,
+		Quality:        90,
+	})
+	if err != nil || !isCMYK(bimg.NewImage(cmyk)) {
+		t.Skip("this libvips build can't write CMYK JPEGs")
+	}
+	return cmyk
+}
+
+func TestCMYKConvertedToSRGB(t *testing.T) {
+	setupTestServer(t, nil)
+	data := testCMYKJPEG(t, 120, 80)
+
+	out, err := compressImage(context.Background(), data, defaultUploadOptions())
+	if err != nil {
+		t.Fatalf("compressImage: %v", err)
+	}
+	img := bimg.NewImage(out)
+	if isCMYK(img) {
+		t.Error("output is still CMYK")
+	}
+	info, err := imageColorInfo(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Colorspace != "srgb" || info.Channels != 3 {
+		t.Errorf("output colorspace = %s with %d channels, want srgb with 3", info.Colorspace, info.Channels)
+	}
+	if dims, err := img.Size(); err != nil || dims.Width != 120 || dims.Height != 80 {
+		t.Errorf("output size = %+v, %v; want 120x80", dims, err)
+	}
+	if hasProfile(img) {
+		t.Error("the embedded profile was not stripped")
+	}
+}
+
+// testPNG16 encodes a w x h PNG with 16 bits per channel
+func testPNG16(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.NRGBA64{uint16(x * 65535 / w), uint16(y * 65535 / h), 0x1234, 0xffff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngBitDepth reads the bits per channel from a PNG's IHDR chunk
+func pngBitDepth(t *testing.T, data []byte) int {
+	t.Helper()
+	if sniffFormat(data) != "png" || len(data) < 25 {
+		t.Fatalf("output is %q, not a PNG", sniffFormat(data))
+	}
+	return int(data[24])
+}
+
+func TestNormalizeBitDepth(t *testing.T) {
+	data := testPNG16(t, 64, 48)
+	if pngBitDepth(t, data) != 16 {
+		t.Fatal("test image is not 16-bit")
+	}
+	if interpretation, _ := bimg.ImageInterpretation(data); interpretation != bimg.InterpretationRGB16 {
+		t.Skip("this libvips build doesn't report 16-bit images")
+	}
+
+	for _, tt := range []struct {
+		normalize string
+		want      int
+	}{
+		{"true", 8},
+		{"false", 16},
+	} {
+		setupTestServer(t, map[string]string{"NORMALIZE_BIT_DEPTH": tt.normalize})
+		out, err := compressImage(context.Background(), data, defaultUploadOptions())
+		if err != nil {
+			t.Fatalf("NORMALIZE_BIT_DEPTH=%s: compressImage: %v", tt.normalize, err)
+		}
+		if got := pngBitDepth(t, out); got != tt.want {
+			t.Errorf("NORMALIZE_BIT_DEPTH=%s: output is %d-bit, want %d-bit", tt.normalize, got, tt.want)
+		}
+		if dims, err := bimg.Size(out); err != nil || dims.Width != 64 || dims.Height != 48 {
+			t.Errorf("NORMALIZE_BIT_DEPTH=%s: output size = %+v, %v; want 64x48", tt.normalize, dims, err)
+		}
+	}
+}
+
+func TestLosslessWebP(t *testing.T) {
+	setupTestServer(t, nil)
+	if !canSave(bimg.WEBP) {
+		t.Skip("this libvips build can't encode WebP")
+	}
+	// A flat-colour diagram, which lossless encoding keeps tiny
+	img := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for x := 0; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			c := color.NRGBA{255, 255, 255, 255}
+			if x > 50 && x < 150 && y > 25 && y < 75 {
+				c = color.NRGBA{0, 90, 200, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+
+	for _, lossless := range []bool{true, false} {
+		opts := defaultUploadOptions()
+		opts.Format, opts.Lossless = bimg.WEBP, lossless
+		out, err := compressImage(context.Background(), buf.Bytes(), opts)
+		if err != nil {
+			t.Fatalf("lossless=%v: compressImage: %v", lossless, err)
+		}
+		if sniffFormat(out) != "webp" {
+			t.Fatalf("lossless=%v: output is %q, want webp", lossless, sniffFormat(out))
+		}
+		if got := isLosslessWebP(out); got != lossless {
+			t.Errorf("lossless=%v: output is lossless = %v", lossless, got)
+		}
+	}
+}
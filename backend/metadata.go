@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileMeta is the sidecar record kept alongside a stored file
+type fileMeta struct {
+	// ExpiresAt overrides the global UPLOAD_TTL for this file when set
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// DeleteTokenHash is the SHA-256 of the deletion token returned at upload
+	DeleteTokenHash string `json:"delete_token_hash,omitempty"`
+	// Pending uploads expire at ExpiresAt, the end of the grace period,
+	// unless committed with the token hashed in CommitTokenHash. Committing
+	// sets the expiry to CommittedExpiresAt, the one requested at upload;
+	// the hash is kept so repeated commits can still be checked.
+	Pending            bool       `json:"pending,omitempty"`
+	CommitTokenHash    string     `json:"commit_token_hash,omitempty"`
+	CommittedExpiresAt *time.Time `json:"committed_expires_at,omitempty"`
+	// SHA256 is the hash of the stored bytes, for integrity checks
+	SHA256 string `json:"sha256,omitempty"`
+	// Tags are the image's key/value tags, such as a caption, set through
+	// PUT /images/:filename/meta
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// metadataStore keeps one fileMeta record per stored filename. Records are
+// written as JSON files under dir, or kept in memory when dir is empty.
+type metadataStore struct {
+	mu      sync.Mutex
+	dir     string
+	records map[string]fileMeta
+}
+
+// meta is the metadata store in effect, created in main next to the storage backend
+var meta *metadataStore
+
+// newMetadataStore creates a metadata store writing sidecars to dir, or an
+// in-memory one when dir is empty
+func newMetadataStore(dir string) *metadataStore {
+	return &metadataStore{dir: dir, records: make(map[string]fileMeta)}
+}
+
+// Get returns the record for name, and false when there is none
+func (m *metadataStore) Get(name string) (fileMeta, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dir == "" {
+		record, ok := m.records[name]
+		return record, ok, nil
+	}
+
+	data, err := os.ReadFile(m.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return fileMeta{}, false, nil
+	}
+	if err != nil {
+		return fileMeta{}, false, err
+	}
+	var record fileMeta
+	if err := json.Unmarshal(data, &record); err != nil {
+		return fileMeta{}, false, err
+	}
+	return record, true, nil
+}
+
+// Put stores the record for name, replacing any existing one
+func (m *metadataStore) Put(name string, record fileMeta) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dir == "" {
+		m.records[name] = record
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(m.path(name), data, 0644)
+}
+
+// Delete removes the record for name. Deleting a missing record is not an error.
+func (m *metadataStore) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dir == "" {
+		delete(m.records, name)
+		return nil
+	}
+
+	if err := os.Remove(m.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Check verifies that sidecar records can be written
+func (m *metadataStore) Check() error {
+	if m.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	return checkWritableDir(m.dir)
+}
+
+// path returns the sidecar file path for name
+func (m *metadataStore) path(name string) string {
+	return filepath.Join(m.dir, name+".json")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+// imageExtensions maps the formats libvips can write to their file extension
+var imageExtensions = map[bimg.ImageType]string{
+	bimg.JPEG: ".jpg",
+	bimg.PNG:  ".png",
+	bimg.WEBP: ".webp",
+	bimg.GIF:  ".gif",
+	bimg.TIFF: ".tiff",
+	bimg.HEIF: ".heic",
+	bimg.AVIF: ".avif",
+}
+
+// sniffedExtensions maps sniffFormat names to their file extension, for
+// formats libvips reads but doesn't identify by type, such as BMP
+var sniffedExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"webp": ".webp",
+	"bmp":  ".bmp",
+}
+
+// detectedExtension returns the extension for the format of data, if known
+func detectedExtension(data []byte) (string, bool) {
+	if ext, ok := imageExtensions[bimg.DetermineImageType(data)]; ok {
+		return ext, true
+	}
+	ext, ok := sniffedExtensions[sniffFormat(data)]
+	return ext, ok
+}
+
+// generateFilename picks the stored filename for a processed image according
+// to FILENAME_SCHEME: a nanosecond timestamp with the uploaded file's
+// extension (or the detected format's when the image was converted or the
+// upload had none), or the SHA-256 of the stored bytes with the extension of
+// their actual format, so identical images always map to the same file.
+// With LOWERCASE_EXTENSIONS, photo.JPEG is stored as ….jpeg.
+func generateFilename(originalName string, data []byte) string {
+	if cfg.FilenameScheme == "content-hash" {
+		sum := sha256.Sum256(data)
+		ext, ok := detectedExtension(data)
+		if !ok {
+			ext = originalExtension(originalName)
+		}
+		return hex.EncodeToString(sum[:]) + ext
+	}
+
+	ext := originalExtension(originalName)
+	if detected, ok := detectedExtension(data); ok && !sameImageExtension(ext, detected) {
+		ext = detected
+	}
+	timestamp := time.Now().UnixNano()
+	if cfg.PreserveOriginalName {
+		if slug := slugifyFilename(originalName); slug != "" {
+			return fmt.Sprintf("%s-%d%s", slug, timestamp, ext)
+		}
+	}
+	return fmt.Sprintf("%d%s", timestamp, ext)
+}
+
+// originalExtension returns the uploaded file's extension, lowercased with
+// LOWERCASE_EXTENSIONS. Detected extensions are lowercase already.
+func originalExtension(name string) string {
+	ext := filepath.Ext(name)
+	if cfg.LowercaseExtensions {
+		return strings.ToLower(ext)
+	}
+	return ext
+}
+
+// maxSlugLength caps the original-name part of a stored filename
+const maxSlugLength = 50
+
+// slugifyFilename reduces an uploaded filename to lowercase ASCII letters,
+// digits and single dashes, without any directory part or extension, for
+// use in a stored name. It returns "" when nothing usable is left.
+func slugifyFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if b.Len() >= maxSlugLength {
+			break
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+// sameImageExtension reports whether two extensions name the same format
+func sameImageExtension(a, b string) bool {
+	normalize := func(ext string) string {
+		ext = strings.ToLower(ext)
+		if ext == ".jpeg" {
+			return ".jpg"
+		}
+		return ext
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+func TestExtensionlessUpload(t *testing.T) {
+	mem := setupTestServer(t, nil)
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+
+	w := postImage(engine, "/upload", "image", testJPEG(t, 32, 32))
+	if w.Code != consts.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	filename, _ := decodeJSON(t, w)["filename"].(string)
+	if filepath.Ext(filename) != ".jpg" {
+		t.Errorf("stored as %q, want a .jpg extension", filename)
+	}
+	assertStored(t, mem, filename)
+}
+
+func TestGenerateFilenameExtension(t *testing.T) {
+	jpeg := testJPEG(t, 8, 8)
+	png := testPNG(t, 8, 8, 255)
+	tests := []struct {
+		env      map[string]string
+		original string
+		data     []byte
+		want     string
+	}{
+		{nil, "image", jpeg, ".jpg"},
+		{nil, "image", png, ".png"},
+		{nil, "photo.jpg", jpeg, ".jpg"},
+		{nil, "photo.jpeg", jpeg, ".jpeg"}, // an equivalent extension is kept
+		{nil, "misnamed.png", jpeg, ".jpg"},
+		{nil, "photo.JPEG", jpeg, ".jpeg"},
+		{nil, "photo.Png", png, ".png"},
+		{map[string]string{"LOWERCASE_EXTENSIONS": "false"}, "photo.JPEG", jpeg, ".JPEG"},
+		{map[string]string{"FILENAME_SCHEME": "content-hash"}, "photo.JPEG", jpeg, ".jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.original, func(t *testing.T) {
+			setupTestServer(t, tt.env)
+			if got := filepath.Ext(generateFilename(tt.original, tt.data)); got != tt.want {
+				t.Errorf("generateFilename(%q) with %v has extension %q, want %q", tt.original, tt.env, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUppercaseExtensionUpload(t *testing.T) {
+	mem := setupTestServer(t, nil)
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+
+	w := postImage(engine, "/upload", "photo.JPEG", testJPEG(t, 32, 32))
+	if w.Code != consts.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	result := decodeJSON(t, w)
+	filename, _ := result["filename"].(string)
+	if filepath.Ext(filename) != ".jpeg" {
+		t.Errorf("stored as %q, want a .jpeg extension", filename)
+	}
+	if url, _ := result["url"].(string); !strings.HasSuffix(url, filename) {
+		t.Errorf("url %q doesn't end in the stored name %q", url, filename)
+	}
+	assertStored(t, mem, filename)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+)
+
+// natsTimeout bounds connecting to the NATS server and each write to it
+const natsTimeout = 5 * time.Second
+
+// natsPublisher publishes events to a NATS server on <prefix>.<type>
+// subjects, speaking the plain-text client protocol over TCP. It connects
+// on the first event and again after the connection drops. Core NATS
+// doesn't acknowledge messages, so events published while the server is
+// unreachable, or without a subscriber, are lost.
+type natsPublisher struct {
+	addr           string
+	user, password string
+	token          string
+	prefix         string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newNATSPublisher parses a nats://[user:password@]host[:port] URL. A user
+// without a password is sent as an auth token.
+func newNATSPublisher(rawURL, prefix string) (*natsPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid NATS_URL: %q (expected nats://host:port)", rawURL)
+	}
+	p := &natsPublisher{addr: u.Host, prefix: prefix}
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			p.user, p.password = u.User.Username(), password
+		} else {
+			p.token = u.User.Username()
+		}
+	}
+	return p, nil
+}
+
+// Publish sends e as JSON, connecting first when needed
+func (p *natsPublisher) Publish(e event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	if _, err := fmt.Fprintf(p.conn, "PUB %s.%s %d\r\n%s\r\n", p.prefix, e.Type, len(payload), payload); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+// connect dials the server, reads its INFO, and sends CONNECT followed by a
+// PING whose PONG confirms the server accepted it; the caller holds the lock
+func (p *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, natsTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(natsTimeout))
+	r := bufio.NewReader(conn)
+	fail := func(err error) error {
+		conn.Close()
+		return err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fail(err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fail(fmt.Errorf("unexpected greeting from NATS server: %q", strings.TrimSpace(line)))
+	}
+	connectOptions := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "my-backend",
+		"lang":     "go",
+		"version":  "1",
+		"protocol": 1,
+	}
+	if p.user != "" {
+		connectOptions["user"], connectOptions["pass"] = p.user, p.password
+	}
+	if p.token != "" {
+		connectOptions["auth_token"] = p.token
+	}
+	options, err := json.Marshal(connectOptions)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", options); err != nil {
+		return fail(err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fail(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return fail(errors.New("NATS server refused the connection: " + line))
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	p.conn = conn
+	go p.readLoop(conn, r)
+	return nil
+}
+
+// readLoop answers the server's keep-alive PINGs and logs its errors until
+// the connection closes, then drops it so the next event reconnects
+func (p *natsPublisher) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			p.mu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+			_, err = conn.Write([]byte("PONG\r\n"))
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			hlog.Warnf("events: NATS server error: %s", line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn.Close()
+	if p.conn == conn {
+		p.conn = nil
+	}
+}
+
+// Close closes the connection; queued events have already been written
+func (p *natsPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
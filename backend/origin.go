@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// parseAllowedOrigins parses a comma-separated list of origins such as
+// "https://example.com,https://*.example.com". A leading "*." in the host
+// matches any subdomain. An empty list allows every origin.
+func parseAllowedOrigins(s string) ([]string, error) {
+	var origins []string
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		u, err := url.Parse(entry)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.User != nil {
+			return nil, fmt.Errorf("invalid origin %q (expected a scheme and host such as https://example.com)", entry)
+		}
+		origins = append(origins, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return origins, nil
+}
+
+// parseTimingAllowOrigin parses TIMING_ALLOW_ORIGIN: "*", or a
+// comma-separated list of origins, returned as the header value. Browsers
+// match them exactly, so unlike UPLOAD_ALLOWED_ORIGINS there are no wildcards.
+func parseTimingAllowOrigin(s string) (string, error) {
+	if strings.TrimSpace(s) == "*" {
+		return "*", nil
+	}
+	origins, err := parseAllowedOrigins(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid TIMING_ALLOW_ORIGIN: %v", err)
+	}
+	for _, origin := range origins {
+		if strings.Contains(origin, "://*.") {
+			return "", fmt.Errorf("invalid TIMING_ALLOW_ORIGIN: %q (wildcard subdomains are not supported)", origin)
+		}
+	}
+	return strings.Join(origins, ", "), nil
+}
+
+// requestOrigin returns the origin a browser says a request comes from: its
+// Origin header, or else the scheme and host of its Referer. It returns
+// false when the request carries neither, as non-browser clients don't.
+func requestOrigin(c *app.RequestContext) (string, bool) {
+	if origin := string(c.GetHeader("Origin")); origin != "" {
+		return strings.ToLower(origin), true
+	}
+	referer := string(c.GetHeader("Referer"))
+	if referer == "" {
+		return "", false
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return "null", true // matches no allowed origin
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// originAllowed reports whether origin is one of UPLOAD_ALLOWED_ORIGINS
+func originAllowed(origin string) bool {
+	for _, allowed := range cfg.UploadAllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+		// https://*.example.com matches https://a.example.com, not https://example.com
+		if i := strings.Index(allowed, "://*."); i >= 0 {
+			scheme, suffix := allowed[:i+3], allowed[i+4:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) &&
+				len(origin) > len(scheme)+len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requireAllowedOrigin refuses uploads with 403 when their Origin or
+// Referer names a site outside UPLOAD_ALLOWED_ORIGINS. It stops other sites'
+// pages from posting to /upload through their visitors' browsers, but
+// anything other than a browser can send whatever headers it likes, so it
+// is no substitute for API keys.
+func requireAllowedOrigin(ctx context.Context, c *app.RequestContext) {
+	if cfg.UploadAllowedOrigins == nil {
+		c.Next(ctx)
+		return
+	}
+	if origin, ok := requestOrigin(c); ok && !originAllowed(origin) {
+		hlog.CtxInfof(ctx, "refusing upload from origin %q", origin)
+		respond(c, consts.StatusForbidden, map[string]interface{}{
+			"error": "Uploads from this origin are not allowed",
+		})
+		c.Abort()
+		return
+	}
+	c.Next(ctx)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// orphanFile is a leftover temporary file from an upload that never finished
+type orphanFile struct {
+	Path    string
+	Kind    string
+	Size    int64
+	ModTime time.Time
+}
+
+// orphanScanner finds the temporary files abandoned uploads leave behind:
+// multipart spill files and libvips temporaries in TEMP_DIR, and half-written
+// files in the uploads directory from saves interrupted by a crash.
+// Only names this service creates are considered, since TEMP_DIR may be shared.
+type orphanScanner struct {
+	tempDir    string
+	uploadsDir string // empty unless files are stored on disk
+}
+
+// orphans is the scanner in effect, set up in main
+var orphans *orphanScanner
+
+// tempFileKinds maps the name prefixes of temporary files to their kind
+var tempFileKinds = []struct {
+	prefix, kind string
+	inUploads    bool
+}{
+	{"multipart-", "multipart", false},
+	{"vips-", "libvips", false},
+	{".upload-", "partial-upload", true},
+}
+
+// List returns the temporary files currently on disk, oldest first. Files
+// still being written by in-flight requests are included too; their age
+// tells them apart.
+func (s *orphanScanner) List() ([]orphanFile, error) {
+	var files []orphanFile
+	for _, dir := range []struct {
+		path      string
+		inUploads bool
+	}{{s.tempDir, false}, {s.uploadsDir, true}} {
+		if dir.path == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			kind := tempFileKind(entry.Name(), dir.inUploads)
+			if kind == "" {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue // removed since the directory was read
+			}
+			files = append(files, orphanFile{
+				Path:    filepath.Join(dir.path, entry.Name()),
+				Kind:    kind,
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime.Before(files[j].ModTime) })
+	return files, nil
+}
+
+// tempFileKind returns the kind of a temporary file, or "" for other files
+func tempFileKind(name string, inUploads bool) string {
+	for _, k := range tempFileKinds {
+		if k.inUploads == inUploads && strings.HasPrefix(name, k.prefix) {
+			return k.kind
+		}
+	}
+	return ""
+}
+
+// Sweep deletes the temporary files last modified before cutoff, returning
+// how many were removed and their total size
+func (s *orphanScanner) Sweep(cutoff time.Time) (int, int64, error) {
+	files, err := s.List()
+	if err != nil {
+		return 0, 0, err
+	}
+	removed, size := 0, int64(0)
+	for _, file := range files {
+		if !file.ModTime.Before(cutoff) {
+			break // sorted oldest first
+		}
+		if err := os.Remove(file.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			hlog.Warnf("temp sweep: failed to delete %s: %v", file.Path, err)
+			continue
+		}
+		removed++
+		size += file.Size
+	}
+	return removed, size, nil
+}
+
+// startTempSweeper removes temporary files older than ttl every interval
+func startTempSweeper(interval, ttl time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			removed, size, err := orphans.Sweep(now.Add(-ttl))
+			if err != nil {
+				hlog.Errorf("temp sweep: failed to list temporary files: %v", err)
+				continue
+			}
+			if removed > 0 {
+				hlog.Infof("temp sweep: removed %d stale temporary files (%d bytes)", removed, size)
+			}
+		}
+	}()
+}
+
+// handleListOrphans lists leftover temporary files and pending uploads that
+// haven't been committed yet, with their ages and sizes
+func handleListOrphans(ctx context.Context, c *app.RequestContext) {
+	now := time.Now()
+	files, err := orphans.List()
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to list temporary files",
+		})
+		return
+	}
+	tempFiles := make([]map[string]interface{}, 0, len(files))
+	var tempSize int64
+	for _, file := range files {
+		tempFiles = append(tempFiles, map[string]interface{}{
+			"name":        filepath.Base(file.Path),
+			"kind":        file.Kind,
+			"size":        file.Size,
+			"age_seconds": int(now.Sub(file.ModTime).Seconds()),
+		})
+		tempSize += file.Size
+	}
+
+	stored, err := store.List()
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to list uploads",
+		})
+		return
+	}
+	pending := make([]map[string]interface{}, 0)
+	for _, file := range stored {
+		record, _, err := meta.Get(file.Name)
+		if err != nil || !record.Pending {
+			continue
+		}
+		entry := map[string]interface{}{
+			"filename":    file.Name,
+			"size":        file.Size,
+			"age_seconds": int(now.Sub(file.ModTime).Seconds()),
+		}
+		if record.ExpiresAt != nil {
+			entry["expires_at"] = record.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		pending = append(pending, entry)
+	}
+
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"temp_files":      tempFiles,
+		"temp_files_size": tempSize,
+		"pending_uploads": pending,
+	})
+}
+
+// handleCleanupOrphans deletes temporary files older than ?older_than=,
+// defaulting to TEMP_FILE_TTL or an hour when that is unset
+func handleCleanupOrphans(ctx context.Context, c *app.RequestContext) {
+	olderThan := cfg.TempFileTTL
+	if olderThan == 0 {
+		olderThan = time.Hour
+	}
+	if v := c.Query("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			respond(c, consts.StatusBadRequest, map[string]interface{}{
+				"error": fmt.Sprintf("invalid older_than: %q (expected a duration such as 1h)", v),
+			})
+			return
+		}
+		olderThan = d
+	}
+
+	removed, size, err := orphans.Sweep(time.Now().Add(-olderThan))
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to list temporary files",
+		})
+		return
+	}
+	hlog.CtxInfof(ctx, "temp cleanup: removed %d temporary files (%d bytes) older than %s", removed, size, olderThan)
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"removed":       removed,
+		"removed_bytes": size,
+	})
+}
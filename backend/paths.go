@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// canonicalUploadPath returns where a request for a stored file should be
+// redirected: without trailing slashes and, with UPLOADS_CASE_INSENSITIVE,
+// to the stored file whose name matches ignoring case. It returns false
+// when the request already uses the canonical path.
+func canonicalUploadPath(c *app.RequestContext) (string, bool) {
+	requested := c.Param("filepath")
+	trimmed := strings.TrimRight(requested, "/")
+	if trimmed == "" {
+		return "", false
+	}
+	raw := strings.HasSuffix(trimmed, "/raw")
+	name := path.Base(strings.TrimSuffix(trimmed, "/raw"))
+
+	canonical := name
+	if cfg.UploadsCaseInsensitive {
+		if match, ok := matchFileCase(name); ok {
+			canonical = match
+		}
+	}
+	if trimmed == requested && canonical == name {
+		return "", false
+	}
+
+	location := cfg.RoutePrefix + "/uploads/" + canonical
+	if raw {
+		location += "/raw"
+	}
+	if query := c.Request.URI().QueryString(); len(query) > 0 {
+		location += "?" + string(query)
+	}
+	return location, true
+}
+
+// matchFileCase finds the stored file named name ignoring case. An exact
+// match is a single lookup; otherwise every stored name is scanned.
+func matchFileCase(name string) (string, bool) {
+	if exists, err := store.Exists(name); err != nil || exists {
+		return name, exists
+	}
+	files, err := store.List()
+	if err != nil {
+		return "", false
+	}
+	for _, file := range files {
+		if strings.EqualFold(file.Name, name) {
+			return file.Name, true
+		}
+	}
+	return "", false
+}
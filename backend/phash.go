@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// perceptualHash computes a 64-bit difference hash (dHash) of an image. The
+// image is shrunk to 9x8 grayscale and each bit records whether a pixel is
+// brighter than its right-hand neighbour, so resizing or recompressing an
+// image barely changes its hash.
+func perceptualHash(data []byte) (uint64, error) {
+	small, err := bimg.NewImage(data).Process(bimg.Options{
+		Width:          9,
+		Height:         8,
+		Force:          true,
+		Type:           bimg.PNG,
+		Interpretation: bimg.InterpretationBW,
+	})
+	if err != nil {
+		return 0, err
+	}
+	img, err := png.Decode(bytes.NewReader(small))
+	if err != nil {
+		return 0, err
+	}
+	b := img.Bounds()
+	if b.Dx() != 9 || b.Dy() != 8 {
+		return 0, fmt.Errorf("unexpected hash thumbnail size %dx%d", b.Dx(), b.Dy())
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			right := color.GrayModel.Convert(img.At(b.Min.X+x+1, b.Min.Y+y)).(color.Gray).Y
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// formatPHash renders a perceptual hash as 16 hex digits
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// parsePHash parses a perceptual hash written by formatPHash
+func parsePHash(s string) (uint64, error) {
+	if len(s) != 16 {
+		return 0, errors.New("phash must be 16 hex digits")
+	}
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// phashIndex maps stored filenames to their perceptual hash, so similarity
+// queries compare hashes without re-reading any image. It is persisted as a
+// single JSON file, or kept in memory only when path is empty.
+type phashIndex struct {
+	mu     sync.RWMutex
+	path   string
+	hashes map[string]uint64
+}
+
+// phashes is the perceptual hash index in effect, loaded in main
+var phashes *phashIndex
+
+// loadPHashIndex opens the index at path, starting empty if it doesn't exist yet
+func loadPHashIndex(path string) (*phashIndex, error) {
+	idx := &phashIndex{path: path, hashes: make(map[string]uint64)}
+	if path == "" {
+		return idx, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored map[string]string
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("corrupt phash index %s: %v", path, err)
+	}
+	for name, s := range stored {
+		if hash, err := parsePHash(s); err == nil {
+			idx.hashes[name] = hash
+		}
+	}
+	return idx, nil
+}
+
+// Add records the hash of a stored file
+func (idx *phashIndex) Add(name string, hash uint64) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.hashes[name] = hash
+	return idx.save()
+}
+
+// Remove forgets a deleted file
+func (idx *phashIndex) Remove(name string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.hashes[name]; !ok {
+		return nil
+	}
+	delete(idx.hashes, name)
+	return idx.save()
+}
+
+// phashMatch is a stored file within the requested distance of a hash
+type phashMatch struct {
+	name     string
+	hash     uint64
+	distance int
+}
+
+// Similar returns the files whose hash is within threshold bits of hash, closest first
+func (idx *phashIndex) Similar(hash uint64, threshold int) []phashMatch {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var matches []phashMatch
+	for name, h := range idx.hashes {
+		if d := bits.OnesCount64(h ^ hash); d <= threshold {
+			matches = append(matches, phashMatch{name: name, hash: h, distance: d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].name < matches[j].name
+	})
+	return matches
+}
+
+// save writes the index atomically; the caller holds the lock
+func (idx *phashIndex) save() error {
+	if idx.path == "" {
+		return nil
+	}
+	stored := make(map[string]string, len(idx.hashes))
+	for name, hash := range idx.hashes {
+		stored[name] = formatPHash(hash)
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return err
+	}
+	tmp := idx.path + ".tmp"
+	defer os.Remove(tmp) // no-op once renamed
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, idx.path)
+}
+
+// handleSimilarImages lists stored images perceptually similar to a hash
+func handleSimilarImages(ctx context.Context, c *app.RequestContext) {
+	hash, err := parsePHash(c.Query("phash"))
+	if err != nil {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "phash must be 16 hex digits",
+		})
+		return
+	}
+	threshold := 10
+	if v := c.Query("threshold"); v != "" {
+		threshold, err = strconv.Atoi(v)
+		if err != nil || threshold < 0 || threshold > 64 {
+			respond(c, consts.StatusBadRequest, map[string]interface{}{
+				"error": "threshold must be an integer between 0 and 64",
+			})
+			return
+		}
+	}
+
+	matches := phashes.Similar(hash, threshold)
+	results := make([]map[string]interface{}, 0, len(matches))
+	for _, m := range matches {
+		results = append(results, map[string]interface{}{
+			"filename": m.name,
+			"url":      storedFileURL(ctx, m.name),
+			"phash":    formatPHash(m.hash),
+			"distance": m.distance,
+		})
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"matches": results,
+	})
+}
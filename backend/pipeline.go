@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// httpError is an error carrying the status code and message reported to the client
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+// validationErrors are all the problems found with a request, reported
+// together so clients can fix them in one round trip
+type validationErrors []*httpError
+
+func (v validationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// add records a problem, flattening other validationErrors into the list
+func (v *validationErrors) add(err error) {
+	switch e := err.(type) {
+	case nil:
+	case validationErrors:
+		*v = append(*v, e...)
+	case *httpError:
+		*v = append(*v, e)
+	default:
+		*v = append(*v, &httpError{consts.StatusInternalServerError, e.Error()})
+	}
+}
+
+// err returns nil when nothing was recorded, the problem itself when there
+// is one, and the whole list otherwise
+func (v validationErrors) err() error {
+	switch len(v) {
+	case 0:
+		return nil
+	case 1:
+		return v[0]
+	}
+	return v
+}
+
+// errorStatus returns the status code to report for err. Several problems
+// with different statuses are reported as 400.
+func errorStatus(err error) int {
+	switch e := err.(type) {
+	case *httpError:
+		return e.status
+	case validationErrors:
+		for _, item := range e[1:] {
+			if item.status != e[0].status {
+				return consts.StatusBadRequest
+			}
+		}
+		return e[0].status
+	}
+	return consts.StatusInternalServerError
+}
+
+// errorResponse is the response body for err: {"error": message}, plus an
+// "errors" list of each message when several problems were found
+func errorResponse(err error) map[string]interface{} {
+	body := map[string]interface{}{"error": err.Error()}
+	if list, ok := err.(validationErrors); ok {
+		messages := make([]string, len(list))
+		for i, e := range list {
+			messages[i] = e.message
+		}
+		body["errors"] = messages
+	}
+	return body
+}
+
+// uploadOptions are the per-request settings applied when processing an upload
+type uploadOptions struct {
+	// ExpiresIn overrides the global UPLOAD_TTL when positive
+	ExpiresIn time.Duration
+	// Format is the requested output format; bimg.UNKNOWN keeps the input's
+	Format bimg.ImageType
+	// Width and Height are the resize box; zero leaves that side unconstrained
+	Width, Height int
+	// Fit is how the image is fitted into the box (see resize.go)
+	Fit string
+	// Background is the fit=contain padding colour; nil picks the default
+	Background *bimg.Color
+	// NoAutoRotate keeps the EXIF orientation instead of applying it
+	NoAutoRotate bool
+	// Lossless asks for lossless encoding when the output is WebP
+	Lossless bool
+	// Trim removes uniform borders before compression
+	Trim bool
+	// Straighten deskews tilted document scans before compression
+	Straighten bool
+	// DPI, when positive, is the resolution recorded in the output's metadata
+	DPI int
+	// MaxBytes, when positive, lowers the compression target for this upload
+	MaxBytes int
+	// ExpectedSHA256, when set, is the hash the stored image must have
+	ExpectedSHA256 string
+	// Quality encodes once at this quality instead of searching for one
+	// that meets the size target; zero searches
+	Quality int
+	// DefaultQuality is the first quality the size search tries; zero starts at 80
+	DefaultQuality int
+	// Namespace, when set, prefixes the stored filename
+	Namespace string
+	// AllowedFormats, when set, are the only formats the output may be in
+	AllowedFormats map[bimg.ImageType]bool
+}
+
+// defaultUploadOptions returns the options used when a request sets none
+func defaultUploadOptions() uploadOptions {
+	return uploadOptions{Fit: fitInside, NoAutoRotate: !cfg.AutoRotate}
+}
+
+// processTiming is where the time spent processing one image went
+type processTiming struct {
+	// Validation is the time spent checking the content before queueing
+	Validation time.Duration
+	// QueueWait is the time spent waiting for a free worker
+	QueueWait time.Duration
+	// Processing is the time spent compressing once a worker was free
+	Processing time.Duration
+	// Storage is the time spent saving the result; zero when nothing is stored
+	Storage time.Duration
+}
+
+// setHeaders reports the timing to the client, and with SERVER_TIMING=true
+// as a Server-Timing header browsers show in their developer tools
+func (t processTiming) setHeaders(c *app.RequestContext) {
+	c.Header("X-Processing-Queue-Wait-Ms", strconv.FormatInt(t.QueueWait.Milliseconds(), 10))
+	c.Header("X-Processing-Time-Ms", strconv.FormatInt(t.Processing.Milliseconds(), 10))
+	if !cfg.ServerTiming {
+		return
+	}
+	c.Header("Server-Timing", t.serverTiming())
+	// Scripts on other origins only see the entries when allowed to
+	if cfg.TimingAllowOrigin != "" {
+		c.Header("Timing-Allow-Origin", cfg.TimingAllowOrigin)
+	}
+}
+
+// serverTiming formats the stages as Server-Timing metrics, in milliseconds
+func (t processTiming) serverTiming() string {
+	metric := func(name string, d time.Duration) string {
+		return name + ";dur=" + strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 1, 64)
+	}
+	metrics := []string{
+		metric("validate", t.Validation),
+		metric("queue", t.QueueWait),
+		metric("compress", t.Processing),
+	}
+	if t.Storage > 0 {
+		metrics = append(metrics, metric("store", t.Storage))
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// processDetails describes how one image was processed
+type processDetails struct {
+	processTiming
+	// TrimmedSize is the image's size once ?trim=true removed its borders,
+	// before any resize; nil when nothing was trimmed
+	TrimmedSize *bimg.ImageSize
+	// Source is the image that was compressed: the upload after any
+	// trimming and straightening
+	Source []byte
+}
+
+// validateImage checks an image with checkImage before any work is spent on
+// it, returning the time the check took. Each request validates its image
+// once, before processImage, which doesn't check it again however many
+// renditions are made from it.
+func validateImage(ctx context.Context, data []byte) (time.Duration, error) {
+	validating := time.Now()
+	_, span := startSpan(ctx, "validate",
+		attribute.Int("image.size", len(data)),
+		attribute.String("image.format", sniffFormat(data)))
+	err := checkImage(data)
+	endSpan(span, err)
+	return time.Since(validating), err
+}
+
+// processImage compresses an image validateImage accepted, once a worker is
+// free. Errors are *httpError values.
+func processImage(ctx context.Context, data []byte, opts uploadOptions) ([]byte, processDetails, error) {
+	var timing processDetails
+	ctx, cancel := context.WithTimeout(ctx, cfg.ProcessingTimeout)
+	defer cancel()
+	queued := time.Now()
+	if err := pool.Acquire(ctx); err != nil {
+		return nil, timing, processingStopped(ctx, "while waiting for a worker")
+	}
+	defer pool.Release()
+	started := time.Now()
+	timing.QueueWait = started.Sub(queued)
+
+	if err := checkBlocklist(data); err != nil {
+		return nil, timing, err
+	}
+	if opts.Trim {
+		_, span := startSpan(ctx, "trim")
+		data, timing.TrimmedSize = trimImage(ctx, data)
+		endSpan(span, nil)
+	}
+	if opts.Straighten {
+		_, span := startSpan(ctx, "straighten")
+		data = straightenImage(ctx, data, opts)
+		endSpan(span, nil)
+	}
+	timing.Source = data
+	if ctx.Err() != nil {
+		return nil, timing, processingStopped(ctx, "before compression")
+	}
+
+	_, span := startSpan(ctx, "compress",
+		attribute.Int("image.size", len(data)),
+		attribute.Int64("queue_wait_ms", timing.QueueWait.Milliseconds()))
+	compressed, err := compressWithFallback(ctx, data, opts)
+	if err == nil {
+		span.SetAttributes(
+			attribute.Int("image.output_size", len(compressed)),
+			attribute.String("image.output_format", bimg.DetermineImageTypeName(compressed)))
+	}
+	endSpan(span, err)
+	timing.Processing = time.Since(started)
+	if cfg.SlowProcessingThreshold > 0 && timing.QueueWait+timing.Processing >= cfg.SlowProcessingThreshold {
+		hlog.CtxWarnf(ctx, "slow image processing: queue wait %dms, processing %dms, %d bytes",
+			timing.QueueWait.Milliseconds(), timing.Processing.Milliseconds(), len(data))
+	}
+	if cfg.SlowCompressionThreshold > 0 && timing.Processing >= cfg.SlowCompressionThreshold {
+		width, height := 0, 0
+		if dims, err := bimg.Size(data); err == nil {
+			width, height = dims.Width, dims.Height
+		}
+		hlog.CtxWarnf(ctx, "slow compression: %dms for a %d byte %dx%d %s image",
+			timing.Processing.Milliseconds(), len(data), width, height, sniffFormat(data))
+	}
+	if _, ok := err.(*httpError); ok {
+		return nil, timing, err
+	}
+	if err != nil {
+		return nil, timing, &httpError{consts.StatusInternalServerError, fmt.Sprintf("Failed to compress image: %v", err)}
+	}
+	// FORMAT_MAP, FORCE_OUTPUT_FORMAT, a kept original or an encoder
+	// fallback can all pick a format the format parameter wasn't checked for
+	if format := bimg.DetermineImageType(compressed); opts.AllowedFormats != nil && !opts.AllowedFormats[format] {
+		return nil, timing, &httpError{consts.StatusForbidden, fmt.Sprintf("format %s is not allowed for this API key", bimg.ImageTypeName(format))}
+	}
+	return setDPI(embedCopyright(compressed, cfg.CopyrightText), opts.DPI), timing, nil
+}
+
+// processingStopped is the error for processing abandoned at a stage because
+// PROCESSING_TIMEOUT ran out or the client went away. An encode already
+// started can't be interrupted, so the deadline is checked between stages
+// and between the compression's encodes.
+func processingStopped(ctx context.Context, stage string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &httpError{consts.StatusServiceUnavailable, fmt.Sprintf("Image processing exceeded PROCESSING_TIMEOUT %s", stage)}
+	}
+	return &httpError{consts.StatusServiceUnavailable, fmt.Sprintf("Request cancelled %s", stage)}
+}
+
+// checkImage refuses data that isn't a well-formed image this build can
+// decode, that is more elongated than MAX_ASPECT_RATIO, or that
+// ALPHA_POLICY rejects
+func checkImage(data []byte) error {
+	if err := validateImageData(data); err != nil {
+		return err
+	}
+	if err := checkAspectRatio(data); err != nil {
+		return err
+	}
+	if err := checkAlphaPolicy(data); err != nil {
+		return err
+	}
+	if format := bimg.DetermineImageType(data); format != bimg.UNKNOWN && !canLoad(format) {
+		return unsupportedFormat(format)
+	}
+	return nil
+}
+
+// processUpload compresses an uploaded image, stores it and returns the
+// fields describing the stored file. Errors are *httpError values.
+func processUpload(ctx context.Context, originalName string, data []byte, opts uploadOptions) (map[string]interface{}, processTiming, error) {
+	compressed, details, err := processImage(ctx, data, opts)
+	if err != nil {
+		return nil, details.processTiming, err
+	}
+
+	// Generate unique filename
+	filename := generateFilename(originalName, compressed)
+	if opts.Namespace != "" {
+		filename = opts.Namespace + "-" + filename
+	}
+	storing := time.Now()
+	result, err := storeProcessed(ctx, filename, data, details.Source, compressed, opts)
+	details.Storage = time.Since(storing)
+	if err == nil {
+		details.addTrimmed(result)
+		if cfg.Assets && !cfg.DryRun {
+			addAsset(ctx, filename, []assetVariant{{Name: defaultVariant, Filename: filename}}, result)
+		}
+		if !cfg.DryRun && result["deduplicated"] == nil {
+			events.Emit(storedEvent(eventUploaded, result))
+		}
+	}
+	return result, details.processTiming, err
+}
+
+// storeProcessed stores a compressed image under filename, with the expiry
+// and tokens the options and configuration call for, and returns the fields
+// describing the stored file. data is the original upload and source the
+// image compressed from it, after any trimming and straightening; the size
+// target is the source's.
+func storeProcessed(ctx context.Context, filename string, data, source, compressed []byte, opts uploadOptions) (map[string]interface{}, error) {
+	sum := contentHash(compressed)
+	if opts.ExpectedSHA256 != "" && sum != opts.ExpectedSHA256 {
+		return nil, &httpError{consts.StatusUnprocessableEntity, fmt.Sprintf("Stored image SHA-256 %s does not match X-Expected-SHA256", sum)}
+	}
+	phash, phashErr := perceptualHash(compressed)
+
+	var err error
+	var record fileMeta
+	if opts.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(opts.ExpiresIn)
+		record.ExpiresAt = &expiresAt
+	}
+	var deleteToken, commitToken string
+	if cfg.DeleteTokens && !cfg.DryRun {
+		if deleteToken, record.DeleteTokenHash, err = newToken(); err != nil {
+			return nil, &httpError{consts.StatusInternalServerError, "Failed to generate deletion token"}
+		}
+	}
+	// Pending uploads expire after the grace period unless committed, which
+	// restores the expiry they were uploaded with
+	if cfg.PendingUploads && !cfg.DryRun {
+		secret, secretHash, err := newToken()
+		if err != nil {
+			return nil, &httpError{consts.StatusInternalServerError, "Failed to generate commit token"}
+		}
+		commitToken = encodeCommitToken(filename, secret)
+		graceEnd := time.Now().Add(cfg.PendingGracePeriod)
+		record.Pending, record.CommitTokenHash = true, secretHash
+		record.CommittedExpiresAt, record.ExpiresAt = record.ExpiresAt, &graceEnd
+	}
+
+	// DRY_RUN leaves storage and the indexes untouched
+	var deduplicated bool
+	if !cfg.DryRun {
+		_, span := startSpan(ctx, "store",
+			attribute.String("file.name", filename),
+			attribute.Int("file.size", len(compressed)))
+		record, deduplicated, err = saveUpload(filename, compressed, record)
+		span.SetAttributes(attribute.Bool("file.deduplicated", deduplicated))
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+
+		// Index the perceptual hash for similarity queries; the upload
+		// itself doesn't depend on it
+		if phashErr == nil {
+			if err := phashes.Add(filename, phash); err != nil {
+				hlog.CtxWarnf(ctx, "failed to index phash for %s: %v", filename, err)
+			}
+		} else {
+			hlog.CtxWarnf(ctx, "failed to compute phash for %s: %v", filename, phashErr)
+		}
+	}
+
+	result := map[string]interface{}{
+		"original_size":   len(data),
+		"compressed_size": len(compressed),
+		"filename":        filename,
+		"url":             versionedFileURL(ctx, filename, sum),
+		"format":          bimg.DetermineImageTypeName(compressed),
+		"sha256":          sum,
+	}
+	// Flag stored images over their target, e.g. kept by
+	// ON_SIZE_EXCEEDED=store-anyway or as a lossless WebP within
+	// LOSSLESS_MAX_OVERSIZE. A requested ?max_bytes= is always answered.
+	target := uploadTarget(source, opts)
+	if len(compressed) > target {
+		result["size_exceeded"] = true
+		result["target_size"] = target
+	}
+	if opts.MaxBytes > 0 {
+		result["target_size"] = target
+		result["target_met"] = len(compressed) <= target
+	}
+	if phashErr == nil {
+		result["phash"] = formatPHash(phash)
+	}
+	// Colour details describe the stored image; a CMYK original is converted
+	// to sRGB, so its colorspace is reported separately
+	if info, err := imageColorInfo(compressed); err == nil {
+		result["colorspace"] = info.Colorspace
+		result["channels"] = info.Channels
+		result["has_alpha"] = info.HasAlpha
+		if original, err := imageColorInfo(data); err == nil && original.Colorspace != info.Colorspace {
+			result["original_colorspace"] = original.Colorspace
+		}
+	}
+	// EXIF dates are camera-local with no zone, so none is given
+	if captured, ok := captureDate(data); ok {
+		result["captured_at"] = captured.Format("2006-01-02T15:04:05")
+	}
+	if stripped := strippedMetadata(data, compressed); stripped != nil {
+		result["stripped_metadata"] = stripped
+	}
+	if opts.Lossless {
+		result["lossless"] = isLosslessWebP(compressed)
+	}
+	if warnings := processingWarnings(source, compressed, opts); warnings != nil {
+		result["warnings"] = warnings
+	}
+	if cfg.ShortIDs && !cfg.DryRun {
+		addShortID(ctx, filename, result)
+	}
+	if cfg.DryRun {
+		result["dry_run"] = true
+	}
+	if record.Pending {
+		result["pending"] = true
+	}
+	if deduplicated {
+		result["deduplicated"] = true
+	} else {
+		if deleteToken != "" {
+			result["delete_token"] = deleteToken
+		}
+		if commitToken != "" {
+			result["commit_token"] = commitToken
+		}
+	}
+	if expiresAt, ok := fileExpiry(fileInfo{Name: filename, ModTime: time.Now()}, record); ok {
+		result["expires_at"] = expiresAt.UTC().Format(time.RFC3339)
+	}
+	return result, nil
+}
+
+// filenameLocks serializes writers of the same filename. With content-hash
+// names, concurrent uploads of one image all target the same file.
+var filenameLocks = newKeyedMutex()
+
+// saveUpload stores compressed under filename along with its metadata
+// record. When a file of that name already exists (an identical image under
+// content-hash naming) it is kept instead of being rewritten and
+// deduplicated is true; only the record's expiry is then merged into the
+// existing one.
+func saveUpload(filename string, compressed []byte, record fileMeta) (fileMeta, bool, error) {
+	unlock := filenameLocks.Lock(filename)
+	defer unlock()
+
+	expiresAt := record.ExpiresAt
+
+	if cfg.FilenameScheme == "content-hash" {
+		exists, err := store.Exists(filename)
+		if err != nil {
+			return record, false, &httpError{consts.StatusInternalServerError, "Failed to check for an existing image"}
+		}
+		if exists {
+			// Keep the shared file for as long as its longest-lived upload needs it
+			existing, _, err := meta.Get(filename)
+			if err != nil {
+				return record, false, &httpError{consts.StatusInternalServerError, "Failed to read upload metadata"}
+			}
+			if existing.ExpiresAt != nil && (expiresAt == nil || expiresAt.After(*existing.ExpiresAt)) {
+				existing.ExpiresAt = expiresAt
+				if err := meta.Put(filename, existing); err != nil {
+					return record, false, &httpError{consts.StatusInternalServerError, "Failed to save upload metadata"}
+				}
+			}
+			return existing, true, nil
+		}
+	}
+
+	// Save the compressed image
+	if err := store.Save(filename, compressed); err != nil {
+		return record, false, &httpError{consts.StatusInternalServerError, "Failed to save compressed image"}
+	}
+
+	// Record the content hash for integrity checks along with the expiry and
+	// tokens
+	record.SHA256 = contentHash(compressed)
+	if err := meta.Put(filename, record); err != nil {
+		store.Delete(filename)
+		return record, false, &httpError{consts.StatusInternalServerError, "Failed to save upload metadata"}
+	}
+	return record, false, nil
+}
+
+// publicFileURL returns the URL a stored file is served from
+func publicFileURL(ctx context.Context, filename string) string {
+	return publicBaseURL(ctx) + "/uploads/" + filename
+}
+
+// urlVersionLength is how many hex digits of the content hash go in ?v=
+const urlVersionLength = 12
+
+// versionedFileURL returns the URL of a stored file whose content hash is
+// sum. With VERSIONED_URLS on it carries ?v= with the start of the hash, so
+// replacing the file changes its URL and long-lived caches don't keep
+// serving the old bytes. The parameter is ignored when the file is served.
+func versionedFileURL(ctx context.Context, filename, sum string) string {
+	url := publicFileURL(ctx, filename)
+	if !cfg.VersionedURLs || sum == "" {
+		return url
+	}
+	if len(sum) > urlVersionLength {
+		sum = sum[:urlVersionLength]
+	}
+	return url + "?v=" + sum
+}
+
+// storedFileURL is versionedFileURL for a file whose hash is read from its
+// metadata
+func storedFileURL(ctx context.Context, filename string) string {
+	if !cfg.VersionedURLs {
+		return publicFileURL(ctx, filename)
+	}
+	record, _, err := meta.Get(filename)
+	if err != nil {
+		return publicFileURL(ctx, filename)
+	}
+	return versionedFileURL(ctx, filename, record.SHA256)
+}
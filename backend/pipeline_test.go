@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans points the tracer at a recorder for the rest of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := tracer
+	tracer = provider.Tracer("test")
+	t.Cleanup(func() { tracer = previous })
+	return recorder
+}
+
+// spanCount returns how many ended spans have the given name
+func spanCount(recorder *tracetest.SpanRecorder, name string) int {
+	n := 0
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			n++
+		}
+	}
+	return n
+}
+
+func TestUploadValidatesOnce(t *testing.T) {
+	setupTestServer(t, nil)
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+	engine.POST("/process", handleProcess)
+
+	tests := []struct {
+		url      string
+		data     []byte
+		compress int
+	}{
+		{"/upload", testPNG(t, 64, 48, 255), 1},
+		{"/upload?iconset=true", testPNG(t, 512, 512, 255), len(iconSizes)},
+		{"/process", testPNG(t, 64, 48, 255), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			recorder := recordSpans(t)
+			if w := postImage(engine, tt.url, "image.png", tt.data); w.Code != consts.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+			if n := spanCount(recorder, "validate"); n != 1 {
+				t.Errorf("validated %d times, want once", n)
+			}
+			if n := spanCount(recorder, "compress"); n != tt.compress {
+				t.Errorf("compressed %d times, want %d", n, tt.compress)
+			}
+		})
+	}
+}
+
+func TestAllowedFormatsCheckOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want int
+	}{
+		{"kept format", nil, consts.StatusOK},
+		{"format map", map[string]string{"FORMAT_MAP": "png:jpeg"}, consts.StatusForbidden},
+		{"forced format", map[string]string{"FORCE_OUTPUT_FORMAT": "jpeg"}, consts.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := setupTestServer(t, tt.env)
+			engine := newTestEngine()
+			key := apiKey{Name: "png-only", AllowedFormats: map[bimg.ImageType]bool{bimg.PNG: true}}
+			engine.POST("/upload", func(ctx context.Context, c *app.RequestContext) {
+				c.Set(apiKeyContextKey, key)
+				c.Next(ctx)
+			}, handleImageUpload)
+
+			w := postImage(engine, "/upload", "photo.png", testPNG(t, 64, 64, 255))
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if names, _ := mem.List(); tt.want != consts.StatusOK && len(names) != 0 {
+				t.Errorf("refused upload was stored: %v", names)
+			}
+		})
+	}
+}
+
+func TestProcessingTimeout(t *testing.T) {
+	mem := setupTestServer(t, map[string]string{"PROCESSING_TIMEOUT": "1ns"})
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+
+	w := postImage(engine, "/upload", "photo.png", testPNG(t, 64, 64, 255))
+	if w.Code != consts.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503: %s", w.Code, w.Body.String())
+	}
+	if names, _ := mem.List(); len(names) != 0 {
+		t.Errorf("timed out upload was stored: %v", names)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// placeholder is the image served for missing uploads, or nil to answer 404
+var placeholder []byte
+
+// loadPlaceholder reads MISSING_IMAGE_PLACEHOLDER into memory once, so a
+// placeholder that is missing or unreadable only disables the fallback
+// instead of failing every request for a missing file
+func loadPlaceholder(path string) {
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		hlog.Warnf("missing image placeholder disabled: %v", err)
+		return
+	}
+	placeholder = data
+}
+
+// handleMissingFile answers a request for an upload that doesn't exist,
+// with the placeholder image when one is configured
+func handleMissingFile(ctx context.Context, c *app.RequestContext) {
+	if placeholder == nil {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "File not found",
+		})
+		return
+	}
+	// The upload may exist later, so caches must not keep the placeholder
+	c.Header("Cache-Control", "no-store")
+	c.Data(cfg.MissingImageStatus, imageContentType(placeholder), placeholder)
+}
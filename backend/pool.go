@@ -0,0 +1,31 @@
+package main
+
+import "context"
+
+// workerPool bounds how many images are processed at the same time
+type workerPool struct {
+	slots chan struct{}
+}
+
+// pool is the shared processing pool, sized by PROCESSING_WORKERS in main
+var pool *workerPool
+
+// newWorkerPool creates a pool allowing size concurrent workers
+func newWorkerPool(size int) *workerPool {
+	return &workerPool{slots: make(chan struct{}, size)}
+}
+
+// Acquire blocks until a worker slot is free or ctx is done
+func (p *workerPool) Acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire
+func (p *workerPool) Release() {
+	<-p.slots
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// handleProcess runs an uploaded image through the same validation and
+// compression as /upload but returns the resulting bytes directly instead of
+// storing them, for callers using the service as a processing proxy
+func handleProcess(ctx context.Context, c *app.RequestContext) {
+	_, data, err := readUploadedImage(ctx, c)
+	if err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	opts, err := parseUploadOptions(c)
+	if err != nil {
+		respond(c, errorStatus(err), errorResponse(err))
+		return
+	}
+
+	validation, err := validateImage(ctx, data)
+	if err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	processed, details, err := processImage(ctx, data, opts)
+	details.Validation = validation
+	details.setHeaders(c)
+	if err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	setWarningsHeader(c, processingWarnings(details.Source, processed, opts))
+	if details.TrimmedSize != nil {
+		c.Header("X-Trimmed-Size", fmt.Sprintf("%dx%d", details.TrimmedSize.Width, details.TrimmedSize.Height))
+	}
+	c.Data(consts.StatusOK, imageContentType(processed), processed)
+}
+
+// imageContentType returns the MIME type of encoded image data
+func imageContentType(data []byte) string {
+	if ext, ok := detectedExtension(data); ok {
+		if contentType := mime.TypeByExtension(ext); contentType != "" {
+			return contentType
+		}
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// clientIPHeaders are the headers a trusted proxy uses to pass on the client address
+var clientIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs or bare IPs,
+// e.g. "10.0.0.0/8,192.168.1.10". An empty list trusts no proxy.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", entry)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// clientIPFunc derives c.ClientIP(). X-Forwarded-For and X-Real-IP are only
+// honoured when the connection comes from a trusted proxy, and the forwarded
+// chain is walked from the right past further trusted hops, so clients can't
+// spoof their address by sending the headers themselves.
+func clientIPFunc(trusted []*net.IPNet) app.ClientIP {
+	return app.ClientIPWithOption(app.ClientIPOptions{
+		RemoteIPHeaders: clientIPHeaders,
+		TrustedCIDRs:    trusted,
+	})
+}
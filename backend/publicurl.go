@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// PUBLIC_URL_MODE values: where the base of returned URLs comes from
+const (
+	publicURLFixed          = "fixed"           // PUBLIC_URL, or http://localhost:8888
+	publicURLRequestDerived = "request-derived" // the scheme and host of each request
+)
+
+// defaultPublicURL is the base of returned URLs in fixed mode without PUBLIC_URL
+const defaultPublicURL = "http://localhost:8888"
+
+// publicBaseKey is the context key the request-derived base URL is kept under
+type publicBaseKey struct{}
+
+// parsePublicURL checks that PUBLIC_URL is an absolute http(s) URL and
+// returns it without a trailing slash
+func parsePublicURL(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid PUBLIC_URL: %q (expected an absolute http or https URL)", s)
+	}
+	return strings.TrimRight(s, "/"), nil
+}
+
+// publicBaseURL returns the base URL with the route prefix, where every
+// public path is served from: the request's own under
+// PUBLIC_URL_MODE=request-derived, otherwise the one fixed at startup
+func publicBaseURL(ctx context.Context) string {
+	if base, ok := ctx.Value(publicBaseKey{}).(string); ok {
+		return base + cfg.RoutePrefix
+	}
+	if cfg.PublicURL != "" {
+		return cfg.PublicURL + cfg.RoutePrefix
+	}
+	return defaultPublicURL + cfg.RoutePrefix
+}
+
+// publicURLMiddleware derives each request's base URL for
+// PUBLIC_URL_MODE=request-derived and hands it on in the context
+func publicURLMiddleware(ctx context.Context, c *app.RequestContext) {
+	if base, ok := requestBaseURL(c); ok {
+		ctx = context.WithValue(ctx, publicBaseKey{}, base)
+	}
+	c.Next(ctx)
+}
+
+// requestBaseURL returns the scheme and host the client used to reach the
+// server. X-Forwarded-Proto and X-Forwarded-Host are only honoured from a
+// trusted proxy, as for the client address.
+func requestBaseURL(c *app.RequestContext) (string, bool) {
+	scheme, host := "http", string(c.Request.Host())
+	if trustedPeer(c) {
+		if proto := firstHeaderValue(c, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := firstHeaderValue(c, "X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+	}
+	if host == "" || strings.ContainsAny(host, "/\\@?# ") {
+		return "", false
+	}
+	return scheme + "://" + host, true
+}
+
+// firstHeaderValue returns the first entry of a comma-separated header,
+// which a chain of proxies appends to
+func firstHeaderValue(c *app.RequestContext, name string) string {
+	v := string(c.GetHeader(name))
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+// trustedPeer reports whether the connection comes from one of TRUSTED_PROXIES
+func trustedPeer(c *app.RequestContext) bool {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range cfg.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
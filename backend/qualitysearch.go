@@ -0,0 +1,94 @@
+package main
+
+// QUALITY_SEARCH values: how compressImage looks for a quality that meets
+// the size target
+const (
+	qualitySearchBinary = "binary" // bisect the quality range
+	qualitySearchLinear = "linear" // step down by qualityStep from the start quality
+)
+
+// qualityStep is how far apart the linear search's qualities are, and the
+// width of the steps the binary search's coarse pass narrows the range to
+const qualityStep = 10
+
+// minQuality is the lowest quality tried before dimensions are reduced
+const minQuality = 20
+
+// qualityEncoder encodes the image being compressed at a quality
+type qualityEncoder func(quality int) ([]byte, error)
+
+// searchQuality looks for the highest quality from start down to minQuality
+// whose encoding is at most maxSize bytes, with at most MAX_QUALITY_ATTEMPTS
+// encodes. It returns that encoding, or nil with the smallest encoding tried
+// and whether the attempt cap cut the search short.
+func searchQuality(encode qualityEncoder, start, maxSize int) (fit, smallest []byte, capped bool, err error) {
+	if cfg.QualitySearch == qualitySearchLinear {
+		return linearQualitySearch(encode, start, maxSize)
+	}
+	return binaryQualitySearch(encode, start, maxSize)
+}
+
+// linearQualitySearch tries start, start-10, start-20 and so on, stopping
+// at the first quality that fits
+func linearQualitySearch(encode qualityEncoder, start, maxSize int) ([]byte, []byte, bool, error) {
+	var smallest []byte
+	for attempt, quality := 1, start; quality >= minQuality; attempt, quality = attempt+1, quality-qualityStep {
+		if attempt > cfg.MaxQualityAttempts {
+			return nil, smallest, true, nil
+		}
+		compressed, err := encode(quality)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if len(compressed) <= maxSize {
+			return compressed, nil, false, nil
+		}
+		if smallest == nil || len(compressed) < len(smallest) {
+			smallest = compressed
+		}
+	}
+	return nil, smallest, false, nil
+}
+
+// binaryQualitySearch tries start first, so images that already fit cost
+// one encode, then bisects the qualities below it. Output size grows with
+// quality, so each fit narrows the range from below and each miss from
+// above. A coarse pass first finds the qualityStep-wide step the best
+// quality is in, then that step is bisected down to the highest quality
+// that fits: from 80 that takes at most 8 encodes.
+func binaryQualitySearch(encode qualityEncoder, start, maxSize int) ([]byte, []byte, bool, error) {
+	var fit, smallest []byte
+	// The qualities still to search are lo to hi: lo-1 is the best fit so
+	// far (or below minQuality) and hi+1 the lowest quality that didn't fit
+	lo, hi := minQuality, start
+	for attempt, quality := 1, start; lo <= hi; attempt, quality = attempt+1, bisectQuality(lo, hi) {
+		if attempt > cfg.MaxQualityAttempts {
+			return fit, smallest, fit == nil, nil
+		}
+		compressed, err := encode(quality)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if len(compressed) <= maxSize {
+			fit, lo = compressed, quality+1
+		} else {
+			if smallest == nil || len(compressed) < len(smallest) {
+				smallest = compressed
+			}
+			hi = quality - 1
+		}
+	}
+	return fit, smallest, false, nil
+}
+
+// bisectQuality picks the next quality to try between lo and hi. It splits
+// the range into qualityStep-wide steps down from the quality that didn't
+// fit and tries the middle step boundary. Within a single step it tries the
+// middle quality.
+func bisectQuality(lo, hi int) int {
+	steps := (hi - lo + 1 + qualityStep) / qualityStep
+	if steps <= 1 {
+		return (lo + hi + 1) / 2
+	}
+	return hi + 1 - qualityStep*(steps/2)
+}
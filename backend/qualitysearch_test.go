@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+// sizeEncoder encodes quality q as q bytes, so a size target of n bytes is
+// met by every quality up to n, and counts the encodes
+func sizeEncoder(encodes *int) qualityEncoder {
+	return func(quality int) ([]byte, error) {
+		*encodes++
+		return bytes.Repeat([]byte{0}, quality), nil
+	}
+}
+
+// wantQuality is the quality a search from start should settle on for a
+// sizeEncoder target of best bytes, or 0 when nothing fits: the highest
+// quality that fits for the binary search, and the first fitting multiple
+// of qualityStep below start for the linear one
+func wantQuality(search string, start, best int) int {
+	switch {
+	case best < minQuality:
+		return 0
+	case best >= start:
+		return start
+	case search == qualitySearchLinear:
+		return start - qualityStep*((start-best+qualityStep-1)/qualityStep)
+	}
+	return best
+}
+
+func TestQualitySearch(t *testing.T) {
+	setupTestServer(t, nil)
+	// The linear search only tries qualities a multiple of 10 below start,
+	// which reach minQuality from 80
+	tests := []struct {
+		search string
+		start  int
+	}{
+		{qualitySearchBinary, 80},
+		{qualitySearchBinary, 65},
+		{qualitySearchBinary, 45},
+		{qualitySearchBinary, minQuality},
+		{qualitySearchLinear, 80},
+	}
+	for _, tt := range tests {
+		cfg.QualitySearch = tt.search
+		for best := 0; best <= 100; best++ {
+			var encodes int
+			fit, smallest, capped, err := searchQuality(sizeEncoder(&encodes), tt.start, best)
+			if err != nil || capped {
+				t.Fatalf("%s search from %d for %d: capped = %v, err = %v", tt.search, tt.start, best, capped, err)
+			}
+			want := wantQuality(tt.search, tt.start, best)
+			switch {
+			case want == 0:
+				if fit != nil || len(smallest) == 0 {
+					t.Errorf("%s search from %d for %d: fit quality %d, smallest %d; want no fit", tt.search, tt.start, best, len(fit), len(smallest))
+				}
+			case len(fit) != want:
+				t.Errorf("%s search from %d for %d: fit quality %d, want %d", tt.search, tt.start, best, len(fit), want)
+			case best >= tt.start && encodes != 1:
+				t.Errorf("%s search from %d for %d: %d encodes, want 1", tt.search, tt.start, best, encodes)
+			}
+		}
+	}
+}
+
+func TestQualitySearchCapped(t *testing.T) {
+	setupTestServer(t, map[string]string{"MAX_QUALITY_ATTEMPTS": "3"})
+	for best := minQuality; best < 80; best++ {
+		var encodes int
+		fit, _, _, err := searchQuality(sizeEncoder(&encodes), 80, best)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encodes > 3 {
+			t.Errorf("search for %d took %d encodes, want at most 3", best, encodes)
+		}
+		if fit != nil && len(fit) > best {
+			t.Errorf("search for %d returned quality %d, which doesn't fit", best, len(fit))
+		}
+	}
+}
+
+func TestQualitySearchEncodes(t *testing.T) {
+	setupTestServer(t, nil)
+	counts := map[string][]int{}
+	for _, search := range []string{qualitySearchBinary, qualitySearchLinear} {
+		cfg.QualitySearch = search
+		for best := minQuality; best < 80; best++ {
+			var encodes int
+			if _, _, _, err := searchQuality(sizeEncoder(&encodes), 80, best); err != nil {
+				t.Fatal(err)
+			}
+			counts[search] = append(counts[search], encodes)
+		}
+	}
+
+	stats := func(counts []int) (most, total int) {
+		for _, n := range counts {
+			if n > most {
+				most = n
+			}
+			total += n
+		}
+		return most, total
+	}
+	binaryMost, binaryTotal := stats(counts[qualitySearchBinary])
+	linearMost, linearTotal := stats(counts[qualitySearchLinear])
+	t.Logf("encodes from 80: binary at most %d, %d in all; linear at most %d, %d in all",
+		binaryMost, binaryTotal, linearMost, linearTotal)
+	// The default cap must let the binary search finish from 80
+	if binaryMost > cfg.MaxQualityAttempts {
+		t.Errorf("binary search takes up to %d encodes, more than the default MAX_QUALITY_ATTEMPTS %d", binaryMost, cfg.MaxQualityAttempts)
+	}
+}
+
+func BenchmarkQualitySearch(b *testing.B) {
+	cfg.MaxQualityAttempts = 8
+	src := testJPEG(b, 1200, 900)
+	encode := func(quality int) ([]byte, error) {
+		return bimg.NewImage(src).Process(bimg.Options{Type: bimg.JPEG, Quality: quality})
+	}
+	// Targets met just below the start quality, midway and only near the bottom
+	for _, quality := range []int{75, 52, 24} {
+		target, err := encode(quality)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, search := range []string{qualitySearchBinary, qualitySearchLinear} {
+			b.Run(fmt.Sprintf("%s/q%d", search, quality), func(b *testing.B) {
+				cfg.QualitySearch = search
+				encodes := 0
+				counted := func(quality int) ([]byte, error) {
+					encodes++
+					return encode(quality)
+				}
+				for i := 0; i < b.N; i++ {
+					if _, _, _, err := searchQuality(counted, 80, len(target)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(encodes)/float64(b.N), "encodes/op")
+			})
+		}
+	}
+}
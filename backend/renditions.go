@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// rendition is one stored file of an upload and the options that make it
+// from the upload's image
+type rendition struct {
+	Name string
+	Opts uploadOptions
+}
+
+// iconSetName splits the name of an ?iconset=true icon,
+// <base>-<size>x<size><ext>, reporting false for other names
+func iconSetName(name string) (base string, size int, ext string, ok bool) {
+	ext = filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	i := strings.LastIndexByte(stem, '-')
+	if i < 0 {
+		return "", 0, "", false
+	}
+	for _, size := range iconSizes {
+		if stem[i+1:] == fmt.Sprintf("%dx%d", size, size) {
+			return stem[:i], size, ext, true
+		}
+	}
+	return "", 0, "", false
+}
+
+// renditionNames returns the names the files stored along with name would
+// have: every icon of an ?iconset=true set, largest first, or both versions
+// of a ?dual_format=true upload, in dualFormats order. A name that can't be
+// part of either is its own only rendition.
+func renditionNames(name string) []string {
+	if base, _, ext, ok := iconSetName(name); ok {
+		names := make([]string, 0, len(iconSizes))
+		for _, size := range iconSizes {
+			names = append(names, fmt.Sprintf("%s-%dx%d%s", base, size, size, ext))
+		}
+		return names
+	}
+	ext := filepath.Ext(name)
+	for _, format := range dualFormats {
+		if sameImageExtension(ext, imageExtensions[format]) {
+			stem := strings.TrimSuffix(name, ext)
+			names := make([]string, 0, len(dualFormats))
+			for _, format := range dualFormats {
+				if sameImageExtension(ext, imageExtensions[format]) {
+					names = append(names, name)
+				} else {
+					names = append(names, stem+imageExtensions[format])
+				}
+			}
+			return names
+		}
+	}
+	return []string{name}
+}
+
+// renditionSet returns the stored renditions of the upload name belongs to,
+// in renditionNames order: name itself, whether stored or not, and its
+// siblings that are still stored, each with its newRendition options.
+func renditionSet(name string, opts uploadOptions) ([]rendition, error) {
+	var set []rendition
+	for _, n := range renditionNames(name) {
+		if n != name {
+			exists, err := store.Exists(n)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				continue
+			}
+		}
+		r, ok := newRendition(n, opts)
+		if !ok {
+			return nil, fmt.Errorf("can't store an image under a %q name", filepath.Ext(n))
+		}
+		set = append(set, r)
+	}
+	return set, nil
+}
+
+// newRendition returns the rendition stored as name: opts set to the format
+// of its extension and, for an icon, its size. It reports false when images
+// can't be stored under the extension.
+func newRendition(name string, opts uploadOptions) (rendition, bool) {
+	format, ok := extensionFormat(name)
+	if !ok {
+		return rendition{}, false
+	}
+	r := rendition{Name: name, Opts: opts}
+	r.Opts.Format = format
+	if _, size, _, ok := iconSetName(name); ok {
+		r.Opts.Width, r.Opts.Height = size, size
+	}
+	return r, true
+}
+
+// renditionFilenames returns the names of a rendition set
+func renditionFilenames(set []rendition) []string {
+	names := make([]string, len(set))
+	for i, r := range set {
+		names[i] = r.Name
+	}
+	return names
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// replacedFiles records when stored files were last replaced. The static
+// file server keeps files it served recently open for up to
+// consts.FSHandlerCacheDuration, so until then a replaced file is served
+// from storage instead to avoid returning its old bytes.
+var replacedFiles sync.Map // filename -> time.Time
+
+// recentlyReplaced reports whether name was replaced within the static file
+// server's cache window, forgetting older replacements
+func recentlyReplaced(name string) bool {
+	v, ok := replacedFiles.Load(name)
+	if !ok {
+		return false
+	}
+	if time.Since(v.(time.Time)) > consts.FSHandlerCacheDuration {
+		replacedFiles.Delete(name)
+		return false
+	}
+	return true
+}
+
+// extensionFormat returns the format matching a filename's extension, so a
+// replacement keeps the stored name truthful about its content
+func extensionFormat(name string) (bimg.ImageType, bool) {
+	ext := filepath.Ext(name)
+	for format, formatExt := range imageExtensions {
+		if sameImageExtension(ext, formatExt) && canSave(format) {
+			return format, true
+		}
+	}
+	return bimg.UNKNOWN, false
+}
+
+// handleReplaceUpload replaces a stored file with a newly uploaded image,
+// keeping its name and URL. The image is encoded in the format the name's
+// extension says. The request must carry the file's deletion token or the
+// admin token. The file must already exist unless ?create=true, which
+// creates it like an upload. The other icons of an icon set, and the other
+// version of a dual-format upload, are remade from the new image with it.
+func handleReplaceUpload(ctx context.Context, c *app.RequestContext) {
+	filename := c.Param("filename")
+	if !validStoredName(filename) {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid filename",
+		})
+		return
+	}
+	if cfg.FilenameScheme == "content-hash" {
+		respond(c, consts.StatusConflict, map[string]interface{}{
+			"error": "Files can't be replaced when named by their content hash",
+		})
+		return
+	}
+	if _, ok := extensionFormat(filename); !ok {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": fmt.Sprintf("Can't store an image under a %q name", filepath.Ext(filename)),
+		})
+		return
+	}
+	create, _ := strconv.ParseBool(c.Query("create"))
+	// Refuse requests without the right token before spending any work on
+	// them; the check is repeated once the files are locked
+	_, existed, err := checkReplaceTarget(c, filename, create)
+	if err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	_, data, err := readUploadedImage(ctx, c)
+	if err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	opts, err := parseUploadOptions(c)
+	if err != nil {
+		respond(c, errorStatus(err), errorResponse(err))
+		return
+	}
+	// A new file is created on its own: the proof it may be written says
+	// nothing about the files that share its name
+	own, _ := newRendition(filename, opts)
+	set := []rendition{own}
+	if existed {
+		if set, err = renditionSet(filename, opts); err != nil {
+			respond(c, consts.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to look up file",
+			})
+			return
+		}
+	}
+
+	// Encode every rendition before anything is overwritten
+	var timing processTiming
+	if timing.Validation, err = validateImage(ctx, data); err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	var details processDetails
+	var ownIndex int
+	encoded := make([][]byte, len(set))
+	for i, r := range set {
+		compressed, d, err := processImage(ctx, data, r.Opts)
+		timing.QueueWait += d.QueueWait
+		timing.Processing += d.Processing
+		if err != nil {
+			timing.setHeaders(c)
+			respond(c, errorStatus(err), map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+		if ext, ok := detectedExtension(compressed); !ok || !sameImageExtension(ext, filepath.Ext(r.Name)) {
+			timing.setHeaders(c)
+			respond(c, consts.StatusInternalServerError, map[string]interface{}{
+				"error": fmt.Sprintf("Failed to encode image as %s", bimg.ImageTypeName(r.Opts.Format)),
+			})
+			return
+		}
+		if r.Name == filename {
+			details, ownIndex = d, i
+		}
+		encoded[i] = compressed
+	}
+	timing.setHeaders(c)
+
+	unlock := filenameLocks.LockAll(renditionFilenames(set)...)
+	defer unlock()
+	record, exists, err := checkReplaceTarget(c, filename, create)
+	if err != nil {
+		respond(c, errorStatus(err), map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	var deleteToken string
+	if !exists && cfg.DeleteTokens && !cfg.DryRun {
+		if deleteToken, record.DeleteTokenHash, err = newToken(); err != nil {
+			respond(c, consts.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to generate deletion token",
+			})
+			return
+		}
+	}
+
+	var result map[string]interface{}
+	var regenerated []map[string]interface{}
+	for i, r := range set {
+		compressed := encoded[i]
+		// Keep each file's expiry and deletion token unless the request
+		// sets a new expiry, and record the new content hash
+		fileRecord := record
+		if r.Name != filename {
+			ok, err := store.Exists(r.Name)
+			if err == nil && !ok {
+				continue // deleted since the set was looked up
+			}
+			if err == nil {
+				fileRecord, _, err = meta.Get(r.Name)
+			}
+			if err != nil {
+				respond(c, consts.StatusInternalServerError, map[string]interface{}{
+					"error": "Failed to read upload metadata",
+				})
+				return
+			}
+		}
+		if opts.ExpiresIn > 0 {
+			expiresAt := time.Now().Add(opts.ExpiresIn)
+			if fileRecord.Pending {
+				fileRecord.CommittedExpiresAt = &expiresAt
+			} else {
+				fileRecord.ExpiresAt = &expiresAt
+			}
+		}
+		fileRecord.SHA256 = contentHash(compressed)
+		if !cfg.DryRun {
+			if err := store.Save(r.Name, compressed); err != nil {
+				respond(c, consts.StatusInternalServerError, map[string]interface{}{
+					"error": "Failed to save compressed image",
+				})
+				return
+			}
+			replacedFiles.Store(r.Name, time.Now())
+			if err := meta.Put(r.Name, fileRecord); err != nil {
+				hlog.CtxWarnf(ctx, "failed to save metadata for %s: %v", r.Name, err)
+			}
+		}
+
+		fileResult := map[string]interface{}{
+			"compressed_size": len(compressed),
+			"filename":        r.Name,
+			"url":             versionedFileURL(ctx, r.Name, fileRecord.SHA256),
+			"format":          bimg.DetermineImageTypeName(compressed),
+			"sha256":          fileRecord.SHA256,
+		}
+		// The perceptual hash is the one index derived from the file's bytes
+		if cfg.DryRun {
+			if phash, err := perceptualHash(compressed); err == nil {
+				fileResult["phash"] = formatPHash(phash)
+			}
+		} else if phash := reindexPHash(ctx, r.Name, compressed); phash != "" {
+			fileResult["phash"] = phash
+		}
+		if r.Name == filename {
+			record, result = fileRecord, fileResult
+			continue
+		}
+		regenerated = append(regenerated, fileResult)
+		if !cfg.DryRun {
+			e := storedEvent(eventReplaced, fileResult)
+			e.Asset, _ = assets.AssetOf(r.Name)
+			e.Tags = fileRecord.Tags
+			events.Emit(e)
+		}
+	}
+
+	result["message"] = "Image replaced successfully"
+	result["original_size"] = len(data)
+	if warnings := processingWarnings(details.Source, encoded[ownIndex], set[ownIndex].Opts); warnings != nil {
+		result["warnings"] = warnings
+	}
+	details.addTrimmed(result)
+	if regenerated != nil {
+		result["regenerated"] = regenerated
+	}
+	if deleteToken != "" {
+		result["delete_token"] = deleteToken
+	}
+	if cfg.DryRun {
+		result["dry_run"] = true
+	}
+
+	status, kind := consts.StatusOK, eventReplaced
+	if !exists {
+		status, kind = consts.StatusCreated, eventUploaded
+		result["message"] = "Image uploaded and compressed successfully"
+	}
+	if !cfg.DryRun {
+		e := storedEvent(kind, result)
+		e.Asset, _ = assets.AssetOf(filename)
+		e.Tags = record.Tags
+		events.Emit(e)
+	}
+	respond(c, status, result)
+}
+
+// checkReplaceTarget looks up the file a replacement would overwrite and its
+// metadata. A missing file is refused with 404 unless create is set, and an
+// existing one with 403 unless the request proves it may overwrite it: the
+// file's deletion token as ?token=, compared in constant time as for
+// DELETE, or the admin token as an Authorization bearer token.
+func checkReplaceTarget(c *app.RequestContext, filename string, create bool) (fileMeta, bool, error) {
+	exists, err := store.Exists(filename)
+	if err != nil {
+		return fileMeta{}, false, &httpError{consts.StatusInternalServerError, "Failed to look up file"}
+	}
+	if !exists {
+		if !create {
+			return fileMeta{}, false, &httpError{consts.StatusNotFound, "File not found"}
+		}
+		return fileMeta{}, false, nil
+	}
+	record, _, err := meta.Get(filename)
+	if err != nil {
+		return fileMeta{}, true, &httpError{consts.StatusInternalServerError, "Failed to read upload metadata"}
+	}
+	if !mayModifyStoredFile(c, record) {
+		return fileMeta{}, true, &httpError{consts.StatusForbidden, "Replacing a file needs its deletion token or the admin token"}
+	}
+	return record, true, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+func TestReplaceNeedsToken(t *testing.T) {
+	setupTestServer(t, map[string]string{"DELETE_TOKENS": "true", "ADMIN_TOKEN": "admin-secret"})
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+	engine.PUT("/uploads/:filename", handleReplaceUpload)
+
+	w := postImage(engine, "/upload", "photo.png", testPNG(t, 64, 48, 255))
+	if w.Code != consts.StatusOK {
+		t.Fatalf("upload status = %d: %s", w.Code, w.Body.String())
+	}
+	uploaded := decodeJSON(t, w)
+	filename, _ := uploaded["filename"].(string)
+	token, _ := uploaded["delete_token"].(string)
+
+	tests := []struct {
+		name    string
+		query   string
+		headers []ut.Header
+		status  int
+	}{
+		{"no token", "", nil, consts.StatusForbidden},
+		{"wrong token", "?token=wrong", nil, consts.StatusForbidden},
+		{"wrong admin token", "", []ut.Header{{Key: "Authorization", Value: "Bearer wrong"}}, consts.StatusForbidden},
+		{"deletion token", "?token=" + token, nil, consts.StatusOK},
+		{"admin token", "", []ut.Header{{Key: "Authorization", Value: "Bearer admin-secret"}}, consts.StatusOK},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := store.Read(filename)
+			w := sendImage(engine, "PUT", "/uploads/"+filename+tt.query, "new.png", testPNG(t, 40+i, 40, 255), tt.headers...)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			after, _ := store.Read(filename)
+			if changed := string(before) != string(after); changed != (tt.status == consts.StatusOK) {
+				t.Errorf("file changed = %v with status %d", changed, w.Code)
+			}
+		})
+	}
+}
+
+func TestReplaceCreate(t *testing.T) {
+	mem := setupTestServer(t, map[string]string{"DELETE_TOKENS": "true"})
+	engine := newTestEngine()
+	engine.PUT("/uploads/:filename", handleReplaceUpload)
+
+	if w := sendImage(engine, "PUT", "/uploads/new.png", "new.png", testPNG(t, 32, 32, 255)); w.Code != consts.StatusNotFound {
+		t.Fatalf("status without create = %d, want 404", w.Code)
+	}
+	w := sendImage(engine, "PUT", "/uploads/new.png?create=true", "new.png", testPNG(t, 32, 32, 255))
+	if w.Code != consts.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", w.Code, w.Body.String())
+	}
+	token, _ := decodeJSON(t, w)["delete_token"].(string)
+	if token == "" {
+		t.Fatal("created file has no delete_token")
+	}
+	assertStored(t, mem, "new.png")
+
+	// The token proves ownership of the created file
+	if w := sendImage(engine, "PUT", "/uploads/new.png", "new.png", testPNG(t, 16, 16, 255)); w.Code != consts.StatusForbidden {
+		t.Errorf("replacing without the token = %d, want 403", w.Code)
+	}
+	if w := sendImage(engine, "PUT", "/uploads/new.png?token="+token, "new.png", testPNG(t, 16, 16, 255)); w.Code != consts.StatusOK {
+		t.Errorf("replacing with the token = %d, want 200: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReplaceRegeneratesIconSet(t *testing.T) {
+	mem := setupTestServer(t, map[string]string{"ADMIN_TOKEN": "admin-secret"})
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+	engine.PUT("/uploads/:filename", handleReplaceUpload)
+
+	w := postImage(engine, "/upload?iconset=true", "icon.png", testPNG(t, 512, 512, 255))
+	if w.Code != consts.StatusOK {
+		t.Fatalf("icon set status = %d: %s", w.Code, w.Body.String())
+	}
+	icons := mem.Names()
+	if len(icons) != len(iconSizes) {
+		t.Fatalf("icon set stored %v", icons)
+	}
+	before := make(map[string]string)
+	for _, name := range icons {
+		data, _ := mem.Read(name)
+		before[name] = string(data)
+	}
+
+	// Replace one of the smaller icons; every icon follows at its own size
+	base, _, ext, _ := iconSetName(icons[0])
+	replaced := base + "-32x32" + ext
+	w = sendImage(engine, "PUT", "/uploads/"+replaced, "new.png", testPNG(t, 600, 600, 128),
+		ut.Header{Key: "Authorization", Value: "Bearer admin-secret"})
+	if w.Code != consts.StatusOK {
+		t.Fatalf("replace status = %d: %s", w.Code, w.Body.String())
+	}
+	regenerated, _ := decodeJSON(t, w)["regenerated"].([]interface{})
+	if len(regenerated) != len(iconSizes)-1 {
+		t.Errorf("regenerated %d icons, want %d", len(regenerated), len(iconSizes)-1)
+	}
+	assertStored(t, mem, icons...)
+	for _, name := range icons {
+		data, _ := mem.Read(name)
+		if string(data) == before[name] {
+			t.Errorf("%s wasn't regenerated", name)
+		}
+		_, size, _, _ := iconSetName(name)
+		if dims, err := bimg.Size(data); err != nil || dims.Width != size || dims.Height != size {
+			t.Errorf("%s is %dx%d, want %dx%d", name, dims.Width, dims.Height, size, size)
+		}
+	}
+}
+
+func TestRenditionNames(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"123.png", []string{"123.png"}},
+		{"123.webp", []string{"123.webp", "123.jpg"}},
+		{"123.jpg", []string{"123.webp", "123.jpg"}},
+		{"123-32x32.png", []string{"123-512x512.png", "123-192x192.png", "123-180x180.png", "123-48x48.png", "123-32x32.png", "123-16x16.png"}},
+		{"123-33x33.png", []string{"123-33x33.png"}},
+	}
+	for _, tt := range tests {
+		got := renditionNames(tt.name)
+		if len(got) != len(tt.want) {
+			t.Errorf("renditionNames(%q) = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("renditionNames(%q) = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// Reprocessing job states
+const (
+	reprocessRunning   = "running"
+	reprocessDone      = "done"
+	reprocessCancelled = "cancelled"
+)
+
+// maxReprocessErrors caps the failures a job keeps for its progress report
+const maxReprocessErrors = 100
+
+// reprocessFilter selects the stored files a reprocessing job covers. Zero
+// fields leave that criterion open.
+type reprocessFilter struct {
+	Formats          map[string]bool // as named by sniffFormat
+	MinSize, MaxSize int64
+	// OlderThan and NewerThan bound the time since the file was last stored
+	OlderThan, NewerThan time.Duration
+}
+
+// parseReprocessFilter reads the format, min_size, max_size, older_than
+// and newer_than query parameters
+func parseReprocessFilter(c *app.RequestContext) (reprocessFilter, error) {
+	var f reprocessFilter
+	var errs validationErrors
+	if v := c.Query("format"); v != "" {
+		f.Formats = make(map[string]bool)
+		for _, name := range strings.Split(v, ",") {
+			format, ok := parseInputFormat(name)
+			if !ok {
+				errs.add(&httpError{consts.StatusBadRequest, fmt.Sprintf("invalid format: %q", strings.TrimSpace(name))})
+				continue
+			}
+			f.Formats[format] = true
+		}
+	}
+	for _, p := range []struct {
+		name string
+		to   *int64
+	}{{"min_size", &f.MinSize}, {"max_size", &f.MaxSize}} {
+		if v := c.Query(p.name); v != "" {
+			n, err := parseByteSize(v)
+			if err != nil {
+				errs.add(&httpError{consts.StatusBadRequest, fmt.Sprintf("invalid %s: %q (expected a size such as 500KB)", p.name, v)})
+			}
+			*p.to = int64(n)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		to   *time.Duration
+	}{{"older_than", &f.OlderThan}, {"newer_than", &f.NewerThan}} {
+		if v := c.Query(p.name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				errs.add(&httpError{consts.StatusBadRequest, fmt.Sprintf("invalid %s: %q (expected a duration such as 720h)", p.name, v)})
+			}
+			*p.to = d
+		}
+	}
+	return f, errs.err()
+}
+
+// matches reports whether a listed file falls within the size and age
+// bounds; its format is only known once it is read
+func (f reprocessFilter) matches(file fileInfo, now time.Time) bool {
+	age := now.Sub(file.ModTime)
+	return (f.MinSize == 0 || file.Size >= f.MinSize) &&
+		(f.MaxSize == 0 || file.Size <= f.MaxSize) &&
+		(f.OlderThan == 0 || age >= f.OlderThan) &&
+		(f.NewerThan == 0 || age <= f.NewerThan)
+}
+
+// reprocessJob is a background run of stored files through the current
+// pipeline. Only one runs at a time; the last one is kept for its report.
+type reprocessJob struct {
+	mu         sync.Mutex
+	cancel     context.CancelFunc
+	status     string
+	startedAt  time.Time
+	finishedAt time.Time
+	total      int
+	processed  int
+	replaced   int
+	unchanged  int
+	skipped    int
+	failed     int
+	savedBytes int64
+	errors     []map[string]interface{}
+}
+
+var (
+	reprocessMu  sync.Mutex
+	reprocessing *reprocessJob
+)
+
+// running reports whether the job is still going
+func (j *reprocessJob) running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status == reprocessRunning
+}
+
+// report returns the job's progress for the admin endpoints
+func (j *reprocessJob) report() map[string]interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	report := map[string]interface{}{
+		"status":      j.status,
+		"started_at":  j.startedAt.UTC().Format(time.RFC3339),
+		"total":       j.total,
+		"processed":   j.processed,
+		"replaced":    j.replaced,
+		"unchanged":   j.unchanged,
+		"skipped":     j.skipped,
+		"failed":      j.failed,
+		"saved_bytes": j.savedBytes,
+		"errors":      j.errors,
+	}
+	if !j.finishedAt.IsZero() {
+		report["finished_at"] = j.finishedAt.UTC().Format(time.RFC3339)
+	}
+	return report
+}
+
+// run reprocesses the files in turn until they are all done or the job is
+// cancelled. Each file waits for a worker like any upload, so live traffic
+// keeps its share of the pool. stored is every file listed when the job
+// started, the filtered ones and their siblings alike.
+func (j *reprocessJob) run(ctx context.Context, files, stored []fileInfo, f reprocessFilter) {
+	listing := make(map[string]fileInfo, len(stored))
+	for _, file := range stored {
+		listing[file.Name] = file
+	}
+	done := make(map[string]bool)
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		outcome, saved, err := reprocessFile(ctx, file, f, listing, done)
+		if ctx.Err() != nil {
+			break // the file was left as it was
+		}
+		j.mu.Lock()
+		j.processed++
+		switch {
+		case err != nil:
+			j.failed++
+			if len(j.errors) < maxReprocessErrors {
+				j.errors = append(j.errors, map[string]interface{}{"filename": file.Name, "error": err.Error()})
+			}
+			hlog.CtxWarnf(ctx, "reprocess: %s: %v", file.Name, err)
+		case outcome == reprocessReplaced:
+			j.replaced++
+			j.savedBytes += saved
+		case outcome == reprocessUnchanged:
+			j.unchanged++
+		default:
+			j.skipped++
+		}
+		j.mu.Unlock()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status, j.finishedAt = reprocessDone, time.Now()
+	if ctx.Err() != nil {
+		j.status = reprocessCancelled
+	}
+	hlog.CtxInfof(ctx, "reprocess %s: %d of %d files processed, %d replaced saving %d bytes, %d failed",
+		j.status, j.processed, j.total, j.replaced, j.savedBytes, j.failed)
+}
+
+// Outcomes of reprocessing one file
+const (
+	reprocessReplaced  = "replaced"
+	reprocessUnchanged = "unchanged"
+	reprocessSkipped   = "skipped"
+)
+
+// reprocessFile runs a stored file through the current pipeline in its own
+// format, so its name and URL stay valid, and stores the result when it is
+// smaller. The stored file is the only copy of the image, so it is the
+// input; a result that isn't smaller would only add generation loss. The
+// file keeps its metadata and expiry, and its perceptual hash is refreshed.
+//
+// The files of an icon set or a dual-format upload are reprocessed together
+// from the first of them, the largest icon or the WebP version, so they
+// stay the same image; listing gives their modification times, and done
+// records the files already handled that way.
+// Files named by their content hash are skipped, as new bytes would no
+// longer match their name.
+func reprocessFile(ctx context.Context, file fileInfo, f reprocessFilter, listing map[string]fileInfo, done map[string]bool) (string, int64, error) {
+	if _, ok := extensionFormat(file.Name); !ok || done[file.Name] {
+		return reprocessSkipped, 0, nil
+	}
+	set, err := renditionSet(file.Name, defaultUploadOptions())
+	if err != nil {
+		return "", 0, err
+	}
+	names := renditionFilenames(set)
+	for _, name := range names {
+		done[name] = true
+	}
+
+	unlock := filenameLocks.LockAll(names...)
+	defer unlock()
+	stored := make([][]byte, len(set))
+	for i, r := range set {
+		if stored[i], err = store.Read(r.Name); err != nil && err != errNotFound {
+			return "", 0, err
+		}
+	}
+	listed := stored[indexOf(names, file.Name)]
+	if listed == nil || stored[0] == nil {
+		return reprocessSkipped, 0, nil // deleted since the listing
+	}
+	if f.Formats != nil && !f.Formats[sniffFormat(listed)] {
+		return reprocessSkipped, 0, nil
+	}
+	if namedByContent(set[0].Name, stored[0]) {
+		return reprocessSkipped, 0, nil
+	}
+
+	// Encode every file of the set before any is overwritten
+	source := stored[0]
+	if _, err := validateImage(ctx, source); err != nil {
+		return "", 0, err
+	}
+	encoded := make([][]byte, len(set))
+	for i, r := range set {
+		if stored[i] == nil {
+			continue
+		}
+		compressed, _, err := processImage(ctx, source, r.Opts)
+		if err != nil {
+			return "", 0, fmt.Errorf("%s: %v", r.Name, err)
+		}
+		if ext, ok := detectedExtension(compressed); !ok || !sameImageExtension(ext, filepath.Ext(r.Name)) {
+			return "", 0, fmt.Errorf("%s: re-encoded as %s instead of its own format", r.Name, sniffFormat(compressed))
+		}
+		if len(compressed) < len(stored[i]) {
+			encoded[i] = compressed
+		}
+	}
+
+	outcome, saved := reprocessUnchanged, int64(0)
+	for i, r := range set {
+		if encoded[i] == nil {
+			continue
+		}
+		info, ok := listing[r.Name]
+		if !ok {
+			info = fileInfo{Name: r.Name, ModTime: time.Now()}
+		}
+		if err := replaceReprocessed(ctx, info, encoded[i]); err != nil {
+			return "", 0, err
+		}
+		outcome = reprocessReplaced
+		saved += int64(len(stored[i]) - len(encoded[i]))
+	}
+	return outcome, saved, nil
+}
+
+// replaceReprocessed stores a reprocessed file over the old one, keeping its
+// metadata and expiry, and announces it. The caller holds its lock.
+func replaceReprocessed(ctx context.Context, file fileInfo, compressed []byte) error {
+	name := file.Name
+	record, _, err := meta.Get(name)
+	if err != nil {
+		return err
+	}
+	// Saving resets the modification time UPLOAD_TTL counts from
+	if expiresAt, ok := fileExpiry(file, record); ok && record.ExpiresAt == nil {
+		record.ExpiresAt = &expiresAt
+	}
+	record.SHA256 = contentHash(compressed)
+	if err := store.Save(name, compressed); err != nil {
+		return err
+	}
+	replacedFiles.Store(name, time.Now())
+	if err := meta.Put(name, record); err != nil {
+		hlog.CtxWarnf(ctx, "failed to save metadata for %s: %v", name, err)
+	}
+	reindexPHash(ctx, name, compressed)
+	e := event{
+		Type:     eventReprocessed,
+		Filename: name,
+		URL:      versionedFileURL(ctx, name, record.SHA256),
+		Size:     len(compressed),
+		Format:   bimg.DetermineImageTypeName(compressed),
+		SHA256:   record.SHA256,
+		Tags:     record.Tags,
+	}
+	e.Asset, _ = assets.AssetOf(name)
+	events.Emit(e)
+	return nil
+}
+
+// namedByContent reports whether a stored name, or the shared name of its
+// icon set, ends in the SHA-256 of data, as FILENAME_SCHEME=content-hash
+// names files
+func namedByContent(name string, data []byte) bool {
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	if base, _, _, ok := iconSetName(name); ok {
+		stem = base
+	}
+	return strings.HasSuffix(stem, contentHash(data))
+}
+
+// indexOf returns the position of name in names, or -1
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// reindexPHash refreshes the perceptual hash of a stored file, the only
+// rendition derived from it, returning it formatted or "" when the image
+// can't be hashed
+func reindexPHash(ctx context.Context, filename string, data []byte) string {
+	phash, err := perceptualHash(data)
+	if err != nil {
+		if err := phashes.Remove(filename); err != nil {
+			hlog.CtxWarnf(ctx, "failed to update phash index for %s: %v", filename, err)
+		}
+		return ""
+	}
+	if err := phashes.Add(filename, phash); err != nil {
+		hlog.CtxWarnf(ctx, "failed to index phash for %s: %v", filename, err)
+	}
+	return formatPHash(phash)
+}
+
+// handleStartReprocess starts reprocessing the stored files matching the
+// filter in the background, answering 202 with the job's progress, or 409
+// while another job runs
+func handleStartReprocess(ctx context.Context, c *app.RequestContext) {
+	f, err := parseReprocessFilter(c)
+	if err != nil {
+		respond(c, errorStatus(err), errorResponse(err))
+		return
+	}
+
+	reprocessMu.Lock()
+	defer reprocessMu.Unlock()
+	if reprocessing != nil && reprocessing.running() {
+		respond(c, consts.StatusConflict, map[string]interface{}{
+			"error": "A reprocessing job is already running",
+		})
+		return
+	}
+
+	stored, err := store.List()
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to list uploads",
+		})
+		return
+	}
+	now := time.Now()
+	var files []fileInfo
+	for _, file := range stored {
+		if f.matches(file, now) {
+			files = append(files, file)
+		}
+	}
+
+	// The job outlives this request, so it gets its own context
+	jobCtx, cancel := context.WithCancel(context.Background())
+	job := &reprocessJob{
+		cancel:    cancel,
+		status:    reprocessRunning,
+		startedAt: now,
+		total:     len(files),
+		errors:    make([]map[string]interface{}, 0),
+	}
+	reprocessing = job
+	hlog.CtxInfof(ctx, "reprocess: started on %d files", len(files))
+	go job.run(jobCtx, files, stored, f)
+	respond(c, consts.StatusAccepted, job.report())
+}
+
+// handleReprocessStatus reports the progress of the current or last job
+func handleReprocessStatus(ctx context.Context, c *app.RequestContext) {
+	reprocessMu.Lock()
+	job := reprocessing
+	reprocessMu.Unlock()
+	if job == nil {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "No reprocessing job has been started",
+		})
+		return
+	}
+	respond(c, consts.StatusOK, job.report())
+}
+
+// handleCancelReprocess stops the running job. The file in progress is left
+// as it was, and files already replaced stay replaced.
+func handleCancelReprocess(ctx context.Context, c *app.RequestContext) {
+	reprocessMu.Lock()
+	job := reprocessing
+	reprocessMu.Unlock()
+	if job == nil {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "No reprocessing job has been started",
+		})
+		return
+	}
+	job.cancel()
+	respond(c, consts.StatusOK, job.report())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// uncompressedPNG re-encodes a PNG without compression, so reprocessing it
+// always comes out smaller
+func uncompressedPNG(t *testing.T, data []byte) []byte {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// reprocessAll lists the stored files and reprocesses the named ones in
+// order, returning each outcome
+func reprocessAll(t *testing.T, names ...string) []string {
+	t.Helper()
+	stored, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listing := make(map[string]fileInfo)
+	for _, file := range stored {
+		listing[file.Name] = file
+	}
+	done := make(map[string]bool)
+	outcomes := make([]string, len(names))
+	for i, name := range names {
+		if outcomes[i], _, err = reprocessFile(context.Background(), listing[name], reprocessFilter{}, listing, done); err != nil {
+			t.Fatalf("reprocessFile(%s): %v", name, err)
+		}
+	}
+	return outcomes
+}
+
+func TestReprocessContentHashNames(t *testing.T) {
+	setupTestServer(t, nil)
+	data := uncompressedPNG(t, testPNG(t, 640, 640, 255))
+	hashed := contentHash(data) + ".png"
+	for _, name := range []string{"1700000000.png", hashed, "ns-" + hashed} {
+		if err := store.Save(name, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outcomes := reprocessAll(t, "1700000000.png", hashed, "ns-"+hashed)
+	want := []string{reprocessReplaced, reprocessSkipped, reprocessSkipped}
+	for i := range want {
+		if outcomes[i] != want[i] {
+			t.Errorf("outcomes = %v, want %v", outcomes, want)
+			break
+		}
+	}
+	if stored, _ := store.Read(hashed); !bytes.Equal(stored, data) {
+		t.Error("a file named by its content hash was rewritten")
+	}
+}
+
+func TestReprocessIconSet(t *testing.T) {
+	mem := setupTestServer(t, nil)
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+	if w := postImage(engine, "/upload?iconset=true", "icon.png", testPNG(t, 512, 512, 255)); w.Code != consts.StatusOK {
+		t.Fatalf("icon set status = %d: %s", w.Code, w.Body.String())
+	}
+	icons := mem.Names()
+	for _, name := range icons {
+		data, _ := mem.Read(name)
+		mem.Save(name, uncompressedPNG(t, data))
+	}
+
+	// Reaching any icon reprocesses the whole set from the largest
+	base, _, ext, _ := iconSetName(icons[0])
+	small, largest := base+"-16x16"+ext, base+"-512x512"+ext
+	outcomes := reprocessAll(t, small, largest)
+	if outcomes[0] != reprocessReplaced || outcomes[1] != reprocessSkipped {
+		t.Errorf("outcomes = %v, want [replaced skipped]", outcomes)
+	}
+	for _, name := range icons {
+		data, _ := mem.Read(name)
+		_, size, _, _ := iconSetName(name)
+		dims, err := bimg.Size(data)
+		if err != nil || dims.Width != size || dims.Height != size {
+			t.Errorf("%s is %dx%d, want %dx%d", name, dims.Width, dims.Height, size, size)
+		}
+		if record, _, _ := meta.Get(name); record.SHA256 != contentHash(data) {
+			t.Errorf("%s wasn't reprocessed", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Fit modes for resizing into a width x height box
+const (
+	fitInside  = "inside"  // scale down to fit within the box, keeping the aspect ratio
+	fitContain = "contain" // like inside, then pad to exactly the box
+	fitCover   = "cover"   // fill the box, cropping the overflow around the centre
+	fitFill    = "fill"    // stretch to the box, ignoring the aspect ratio
+)
+
+// white is the padding colour for images that can't be padded transparently
+var white = bimg.Color{R: 255, G: 255, B: 255}
+
+// parseDimension parses the width or height parameter
+func parseDimension(name, s string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n <= 0 || n > bimg.MaxSize() {
+		return 0, fmt.Errorf("%s must be an integer between 1 and %d", name, bimg.MaxSize())
+	}
+	return n, nil
+}
+
+// parseFit parses the fit parameter
+func parseFit(s string) (string, error) {
+	switch fit := strings.ToLower(strings.TrimSpace(s)); fit {
+	case fitInside, fitContain, fitCover, fitFill:
+		return fit, nil
+	}
+	return "", fmt.Errorf("fit must be one of inside, contain, cover or fill")
+}
+
+// parseHexColor parses a colour given as RRGGBB, with or without a leading #
+func parseHexColor(s string) (bimg.Color, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+	if err != nil || len(b) != 3 {
+		return bimg.Color{}, fmt.Errorf("%q is not a hex colour such as ffffff", s)
+	}
+	return bimg.Color{R: b[0], G: b[1], B: b[2]}, nil
+}
+
+// supportsAlpha reports whether images of format t can be transparent
+func supportsAlpha(t bimg.ImageType) bool {
+	switch t {
+	case bimg.PNG, bimg.WEBP, bimg.AVIF, bimg.HEIF, bimg.GIF, bimg.TIFF:
+		return true
+	}
+	return false
+}
+
+// applyResize sets the resize options for the requested box. With
+// fit=contain the padding is the bg colour, or transparent by default when
+// both the image and the output format have alpha, and white otherwise.
+func applyResize(o *bimg.Options, opts uploadOptions, img *bimg.Image, output bimg.ImageType) {
+	if opts.Width == 0 && opts.Height == 0 {
+		return
+	}
+	o.Width, o.Height = opts.Width, opts.Height
+
+	switch opts.Fit {
+	case fitCover:
+		o.Crop = true
+		o.Gravity = bimg.GravityCentre
+	case fitFill:
+		o.Force = true
+	case fitContain:
+		o.Embed = true
+		o.Extend = bimg.ExtendBackground
+		// libvips pads images with alpha transparently and bimg then
+		// flattens them onto the background
+		if opts.Background != nil {
+			o.Background = flattenColor(*opts.Background)
+			return
+		}
+		if hasAlpha(img) && supportsAlpha(output) {
+			return // transparent padding
+		}
+		o.Background = white
+	}
+}
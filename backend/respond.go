@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// respond writes an API response body. It is JSON unless the client's Accept
+// header prefers XML, for legacy clients that can't parse JSON. JSON is
+// indented for humans when PRETTY_JSON is set or the request has ?pretty=1.
+// Field names are snake_case, or camelCase under JSON_FIELD_CASE=camel.
+func respond(c *app.RequestContext, status int, body map[string]interface{}) {
+	if cfg.JSONFieldCase == "camel" {
+		body = camelCaseKeys(body).(map[string]interface{})
+	}
+	if prefersXML(string(c.GetHeader("Accept"))) {
+		c.Data(status, "application/xml; charset=utf-8", marshalXML("response", body))
+		return
+	}
+	if cfg.PrettyJSON || wantsPretty(c.Query("pretty")) {
+		data, err := json.MarshalIndent(body, "", "  ")
+		if err == nil {
+			c.Data(status, "application/json; charset=utf-8", append(data, '\n'))
+			return
+		}
+	}
+	c.JSON(status, body)
+}
+
+// wantsPretty reports whether the pretty query parameter asks for indented output
+func wantsPretty(v string) bool {
+	pretty, err := strconv.ParseBool(v)
+	return err == nil && pretty
+}
+
+// camelCaseKeys returns a copy of value with the keys of every map in it
+// converted to camelCase. The caller's maps are left untouched.
+func camelCaseKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[camelCase(key)] = camelCaseKeys(item)
+		}
+		return converted
+	case []map[string]interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = camelCaseKeys(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, item := range v {
+			converted[i] = camelCaseKeys(item)
+		}
+		return converted
+	}
+	return value
+}
+
+// camelCase converts a snake_case name such as original_size to originalSize
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// prefersXML reports whether an Accept header ranks XML above JSON.
+// JSON wins ties, so a missing header or */* keeps the default.
+func prefersXML(accept string) bool {
+	xmlQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		switch mediaType {
+		case "application/xml", "text/xml":
+			if q > xmlQ {
+				xmlQ = q
+			}
+		case "application/json", "*/*", "application/*":
+			if q > jsonQ {
+				jsonQ = q
+			}
+		}
+	}
+	return xmlQ > 0 && xmlQ > jsonQ
+}
+
+// marshalXML renders a response body as an XML document. Map keys become
+// element names (sorted, matching the JSON field names) and list entries
+// become <item> elements. Keys that aren't valid element names, such as
+// user-chosen tag names, become <entry name="..."> elements instead.
+func marshalXML(root string, body map[string]interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	writeXMLElement(&buf, root, body)
+	return buf.Bytes()
+}
+
+// writeXMLElement writes value as an element with the given name
+func writeXMLElement(buf *bytes.Buffer, name string, value interface{}) {
+	tag, end := name, name
+	if !isXMLName(name) {
+		var attr bytes.Buffer
+		xml.EscapeText(&attr, []byte(name))
+		tag, end = `entry name="`+attr.String()+`"`, "entry"
+	}
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || ((v.Kind() == reflect.Ptr || v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.IsNil()) {
+		fmt.Fprintf(buf, "<%s/>", tag)
+		return
+	}
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	fmt.Fprintf(buf, "<%s>", tag)
+	switch v.Kind() {
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, fmt.Sprint(key.Interface()))
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeXMLElement(buf, key, v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())).Interface())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			writeXMLElement(buf, "item", v.Index(i).Interface())
+		}
+	default:
+		xml.EscapeText(buf, []byte(fmt.Sprint(v.Interface())))
+	}
+	fmt.Fprintf(buf, "</%s>", end)
+}
+
+// isXMLName reports whether name can be written as an element name: a
+// letter or underscore, then letters, digits, '-', '_' or '.'. Colons are
+// left out, as they would declare a namespace prefix.
+func isXMLName(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case i > 0 && (r == '-' || r == '.' || unicode.IsDigit(r)):
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMarshalXMLUnsafeNames(t *testing.T) {
+	body := map[string]interface{}{
+		"filename": "a.png",
+		"tags": map[string]string{
+			"caption":             "Sunset & sea",
+			"my tag":              "spaced",
+			"a><script>x</script": "injected",
+			`q"uote`:              "quoted",
+			"1st":                 "digit first",
+		},
+	}
+	out := marshalXML("response", body)
+
+	// The document must be well-formed, with only the expected elements
+	var elements, entries []string
+	dec := xml.NewDecoder(bytes.NewReader(out))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("malformed XML: %v\n%s", err, out)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			elements = append(elements, start.Name.Local)
+			if start.Name.Local == "entry" {
+				entries = append(entries, start.Attr[0].Value)
+			}
+		}
+	}
+	if got, want := strings.Join(elements, ","), "response,filename,tags,entry,entry,caption,entry,entry"; got != want {
+		t.Errorf("elements = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(entries, "|"), `1st|a><script>x</script|my tag|q"uote`; got != want {
+		t.Errorf("entry names = %s, want %s", got, want)
+	}
+}
+
+func TestIsXMLName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"caption", true},
+		{"original_size", true},
+		{"_private", true},
+		{"x-y.z9", true},
+		{"légende", true},
+		{"", false},
+		{"1st", false},
+		{"-x", false},
+		{"my tag", false},
+		{"ns:name", false},
+		{"a>b", false},
+	}
+	for _, tt := range tests {
+		if got := isXMLName(tt.name); got != tt.want {
+			t.Errorf("isXMLName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// base62 is the alphabet short IDs are drawn from
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// maxShortIDAttempts bounds the draws spent looking for an unused ID
+const maxShortIDAttempts = 10
+
+// shortIDIndex maps short public IDs to stored filenames and back, so
+// uploads can be shared as /s/<id> while keeping their long names on disk.
+// It is persisted as a single JSON file, or kept in memory only when path is
+// empty.
+type shortIDIndex struct {
+	mu     sync.RWMutex
+	path   string
+	names  map[string]string // id -> filename
+	byName map[string]string // filename -> id
+}
+
+// shortIDs is the short ID index in effect, loaded in main
+var shortIDs *shortIDIndex
+
+// loadShortIDIndex opens the index at path, starting empty if it doesn't exist yet
+func loadShortIDIndex(path string) (*shortIDIndex, error) {
+	idx := &shortIDIndex{path: path, names: make(map[string]string), byName: make(map[string]string)}
+	if path == "" {
+		return idx, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &idx.names); err != nil {
+		return nil, fmt.Errorf("corrupt short ID index %s: %v", path, err)
+	}
+	for id, name := range idx.names {
+		idx.byName[name] = id
+	}
+	return idx, nil
+}
+
+// Assign returns the short ID of a stored file, drawing a new random one
+// when it has none. IDs already in use are drawn again, so none is reused.
+func (idx *shortIDIndex) Assign(name string) (string, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if id, ok := idx.byName[name]; ok {
+		return id, nil
+	}
+	for attempt := 0; attempt < maxShortIDAttempts; attempt++ {
+		id, err := randomShortID(cfg.ShortIDLength)
+		if err != nil {
+			return "", err
+		}
+		if _, taken := idx.names[id]; taken {
+			continue
+		}
+		idx.names[id], idx.byName[name] = name, id
+		if err := idx.save(); err != nil {
+			delete(idx.names, id)
+			delete(idx.byName, name)
+			return "", err
+		}
+		return id, nil
+	}
+	return "", fmt.Errorf("no unused short ID found in %d attempts; raise SHORT_ID_LENGTH", maxShortIDAttempts)
+}
+
+// Lookup returns the filename a short ID stands for
+func (idx *shortIDIndex) Lookup(id string) (string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	name, ok := idx.names[id]
+	return name, ok
+}
+
+// Remove forgets the short ID of a deleted file
+func (idx *shortIDIndex) Remove(name string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	id, ok := idx.byName[name]
+	if !ok {
+		return nil
+	}
+	delete(idx.names, id)
+	delete(idx.byName, name)
+	return idx.save()
+}
+
+// save writes the index atomically; the caller holds the lock
+func (idx *shortIDIndex) save() error {
+	if idx.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(idx.names)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return err
+	}
+	tmp := idx.path + ".tmp"
+	defer os.Remove(tmp) // no-op once renamed
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, idx.path)
+}
+
+// randomShortID draws n base62 characters from crypto/rand, rejecting bytes
+// that would bias the draw
+func randomShortID(n int) (string, error) {
+	id := make([]byte, 0, n)
+	buf := make([]byte, n*2)
+	for len(id) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if b < 248 && len(id) < n { // 248 = 4 * 62
+				id = append(id, base62[b%62])
+			}
+		}
+	}
+	return string(id), nil
+}
+
+// validShortID reports whether id could be a short ID, before it is looked up
+func validShortID(id string) bool {
+	if id == "" || len(id) != cfg.ShortIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if !strings.ContainsRune(base62, rune(id[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicShortURL returns the public URL of a short ID
+func publicShortURL(ctx context.Context, id string) string {
+	return publicBaseURL(ctx) + "/s/" + id
+}
+
+// addShortID gives a newly stored file its short ID in the upload result
+func addShortID(ctx context.Context, filename string, result map[string]interface{}) {
+	id, err := shortIDs.Assign(filename)
+	if err != nil {
+		// The upload is stored and still reachable by its filename
+		hlog.CtxWarnf(ctx, "failed to assign a short ID to %s: %v", filename, err)
+		return
+	}
+	result["short_id"] = id
+	result["short_url"] = publicShortURL(ctx, id)
+}
+
+// handleShortID serves the file a short ID stands for, or redirects to its
+// /uploads URL when another server serves the files (SERVE_STATIC=false)
+func handleShortID(ctx context.Context, c *app.RequestContext) {
+	id := c.Param("id")
+	name, ok := "", false
+	if validShortID(id) {
+		name, ok = shortIDs.Lookup(id)
+	}
+	if !ok {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "File not found",
+		})
+		return
+	}
+	if !cfg.ServeStatic {
+		c.Redirect(consts.StatusFound, []byte(publicFileURL(ctx, name)))
+		return
+	}
+	setHeaders(c, cfg.UploadsHeaders)
+	serveStoredFile(ctx, c, name, handleMissingFile)
+}
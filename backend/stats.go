@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"math"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+const (
+	// statsSampleSize is the side of the thumbnail statistics are computed on
+	statsSampleSize = 128
+	// histogramBuckets is how many buckets each channel's histogram has
+	histogramBuckets = 16
+
+	// An image is likely dark when its mean brightness (0-255) is below
+	// darkBrightness, and likely overexposed when more than clippedFraction
+	// of its pixels are near white
+	darkBrightness  = 60
+	clippedLuma     = 250
+	clippedFraction = 0.1
+)
+
+// imageStats are the brightness statistics of an image
+type imageStats struct {
+	MeanBrightness float64
+	Histograms     map[string][]float64 // per channel, as fractions of pixels
+	Dark           bool
+	Overexposed    bool
+}
+
+// computeImageStats measures an image's brightness on a small sRGB
+// thumbnail, which is plenty for histograms and keeps large images cheap
+func computeImageStats(data []byte) (imageStats, error) {
+	small, err := bimg.NewImage(data).Process(bimg.Options{
+		Width:          statsSampleSize,
+		Height:         statsSampleSize,
+		Force:          true,
+		Type:           bimg.PNG,
+		Interpretation: bimg.InterpretationSRGB,
+	})
+	if err != nil {
+		return imageStats{}, err
+	}
+	img, err := png.Decode(bytes.NewReader(small))
+	if err != nil {
+		return imageStats{}, err
+	}
+
+	var counts [3][histogramBuckets]int
+	var lumaSum float64
+	clipped, pixels := 0, 0
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			rgb := [3]uint32{r >> 8, g >> 8, bl >> 8}
+			for ch, v := range rgb {
+				counts[ch][v*histogramBuckets/256]++
+			}
+			// Rec. 709 luma
+			luma := 0.2126*float64(rgb[0]) + 0.7152*float64(rgb[1]) + 0.0722*float64(rgb[2])
+			lumaSum += luma
+			if luma >= clippedLuma {
+				clipped++
+			}
+			pixels++
+		}
+	}
+	if pixels == 0 {
+		return imageStats{}, nil
+	}
+
+	stats := imageStats{
+		MeanBrightness: roundTo(lumaSum/float64(pixels), 1),
+		Histograms:     make(map[string][]float64, 3),
+	}
+	for ch, name := range []string{"red", "green", "blue"} {
+		histogram := make([]float64, histogramBuckets)
+		for i, n := range counts[ch] {
+			histogram[i] = roundTo(float64(n)/float64(pixels), 3)
+		}
+		stats.Histograms[name] = histogram
+	}
+	stats.Dark = stats.MeanBrightness < darkBrightness
+	stats.Overexposed = float64(clipped)/float64(pixels) > clippedFraction
+	return stats, nil
+}
+
+// roundTo rounds v to the given number of decimal places
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+// handleImageStats returns brightness statistics for a stored image
+func handleImageStats(ctx context.Context, c *app.RequestContext) {
+	filename := c.Param("filename")
+	if !validStoredName(filename) {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid filename",
+		})
+		return
+	}
+	data, err := store.Read(filename)
+	if err == errNotFound {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "File not found",
+		})
+		return
+	}
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to read file",
+		})
+		return
+	}
+
+	if err := pool.Acquire(ctx); err != nil {
+		respond(c, consts.StatusServiceUnavailable, map[string]interface{}{
+			"error": "Request cancelled while waiting for a worker",
+		})
+		return
+	}
+	stats, err := computeImageStats(data)
+	pool.Release()
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to analyze image",
+		})
+		return
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"filename":          filename,
+		"mean_brightness":   stats.MeanBrightness,
+		"histograms":        stats.Histograms,
+		"histogram_buckets": histogramBuckets,
+		"dark":              stats.Dark,
+		"overexposed":       stats.Overexposed,
+	})
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// errNotFound is returned by storage backends when a file does not exist
+var errNotFound = errors.New("file not found")
+
+// storage persists processed images by filename
+type storage interface {
+	// Save stores data under name, replacing any existing file
+	Save(name string, data []byte) error
+	// Read returns the contents stored under name, or errNotFound
+	Read(name string) ([]byte, error)
+	// Exists reports whether a file is stored under name
+	Exists(name string) (bool, error)
+	// Delete removes the file stored under name, or returns errNotFound
+	Delete(name string) error
+	// List returns every stored file
+	List() ([]fileInfo, error)
+	// Check reports why the backend can't store files right now, if it can't
+	Check() error
+}
+
+// fileInfo describes a stored file
+type fileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// store is the storage backend in effect, selected by STORAGE_BACKEND in main
+var store storage
+
+// newStorage creates the storage backend for the given kind ("disk" or "memory")
+func newStorage(kind, uploadsDir string) (storage, error) {
+	switch kind {
+	case "disk":
+		return &diskStorage{dir: uploadsDir}, nil
+	case "memory":
+		return newMemoryStorage(), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", kind)
+}
+
+// diskStorage stores files in a local directory
+type diskStorage struct {
+	dir string
+}
+
+// Save writes the file, creating the uploads directory if it doesn't exist.
+// The data goes to a temporary file that is then renamed into place, so
+// readers never see a partially written file.
+func (s *diskStorage) Save(name string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create uploads directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+// Check verifies that the uploads directory exists or can be created, and is writable
+func (s *diskStorage) Check() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	return checkWritableDir(s.dir)
+}
+
+// Exists reports whether the file is in the uploads directory
+func (s *diskStorage) Exists(name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Read reads the file from the uploads directory
+func (s *diskStorage) Read(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotFound
+	}
+	return data, err
+}
+
+// Delete removes the file from the uploads directory
+func (s *diskStorage) Delete(name string) error {
+	err := os.Remove(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return errNotFound
+	}
+	return err
+}
+
+// List returns the regular files in the uploads directory
+func (s *diskStorage) List() ([]fileInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := make([]fileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue // skip in-progress temporary files
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed since the directory was read
+		}
+		files = append(files, fileInfo{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+// memoryStorage keeps files in a map. Nothing survives a restart, so it is
+// meant for tests and throwaway environments rather than production.
+type memoryStorage struct {
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+// memoryFile is a file held by memoryStorage
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// newMemoryStorage creates an empty in-memory storage backend
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{files: make(map[string]memoryFile)}
+}
+
+// Save stores a copy of data so later changes by the caller don't leak in
+func (s *memoryStorage) Save(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = memoryFile{data: append([]byte(nil), data...), modTime: time.Now()}
+	return nil
+}
+
+// Read returns a copy of the stored file
+func (s *memoryStorage) Read(name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	file, ok := s.files[name]
+	if !ok {
+		return nil, errNotFound
+	}
+	return append([]byte(nil), file.data...), nil
+}
+
+// Check always succeeds, since memory storage has no external dependency
+func (s *memoryStorage) Check() error {
+	return nil
+}
+
+// Exists reports whether the file is stored
+func (s *memoryStorage) Exists(name string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.files[name]
+	return ok, nil
+}
+
+// Delete removes the stored file
+func (s *memoryStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return errNotFound
+	}
+	delete(s.files, name)
+	return nil
+}
+
+// List returns every stored file
+func (s *memoryStorage) List() ([]fileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	files := make([]fileInfo, 0, len(s.files))
+	for name, file := range s.files {
+		files = append(files, fileInfo{Name: name, Size: int64(len(file.data)), ModTime: file.modTime})
+	}
+	return files, nil
+}
+
+// Names returns the stored filenames in sorted order
+func (s *memoryStorage) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Len returns the number of stored files
+func (s *memoryStorage) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.files)
+}
+
+// Reset removes every stored file
+func (s *memoryStorage) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = make(map[string]memoryFile)
+}
+
+// handleStoredFile serves files from the storage backend for backends
+// that aren't a local directory the static file server can read
+func handleStoredFile(ctx context.Context, c *app.RequestContext) {
+	serveStoredFile(ctx, c, filepath.Base(c.Param("filepath")), handleMissingFile)
+}
+
+// newDiskFileHandler serves files straight from dir with the static file
+// server. That server keeps recently served files open, so each request
+// first checks the file is still on disk: a deleted file, or a directory
+// removed with everything in it (e.g. by a volume remount), is a clean 404
+// instead of stale bytes. A missing directory is recreated for new uploads.
+func newDiskFileHandler(dir string) app.HandlerFunc {
+	// Strip ROUTE_PREFIX and /uploads to get the path under dir
+	depth := strings.Count(cfg.RoutePrefix, "/") + 1
+	fs := (&app.FS{Root: dir, PathRewrite: app.NewPathSlashesStripper(depth), PathNotFound: handleMissingFile, AcceptByteRange: true}).NewRequestHandler()
+	return func(ctx context.Context, c *app.RequestContext) {
+		name := filepath.Base(c.Param("filepath"))
+		if recentlyReplaced(name) {
+			serveStoredFile(ctx, c, name, handleMissingFile)
+			return
+		}
+		// The static file server can't serve several ranges in one response
+		// and would refuse them; the whole file is a valid answer instead
+		if isMultiRange(c) {
+			c.Request.Header.Del("Range")
+		}
+		info, err := os.Stat(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+				hlog.CtxWarnf(ctx, "uploads directory %s is missing, recreating it", dir)
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					hlog.CtxErrorf(ctx, "failed to recreate uploads directory %s: %v", dir, err)
+				}
+			}
+			handleMissingFile(ctx, c)
+			return
+		}
+		fs(ctx, c)
+		if c.Response.StatusCode() == consts.StatusRequestedRangeNotSatisfiable && err == nil {
+			c.Header("Accept-Ranges", "bytes")
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", info.Size()))
+		}
+	}
+}
+
+// isRawRequest reports whether a file request asks for the stored bytes
+// verbatim, as /uploads/<name>/raw or with ?raw=1
+func isRawRequest(c *app.RequestContext) bool {
+	raw, err := strconv.ParseBool(c.Query("raw"))
+	return strings.HasSuffix(c.Param("filepath"), "/raw") || (err == nil && raw)
+}
+
+// handleRawFile returns a stored file exactly as stored, bypassing any
+// transforms and the missing-file placeholder, for downloads and integrity checks
+func handleRawFile(ctx context.Context, c *app.RequestContext) {
+	name := filepath.Base(strings.TrimSuffix(c.Param("filepath"), "/raw"))
+	serveStoredFile(ctx, c, name, func(ctx context.Context, c *app.RequestContext) {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "File not found",
+		})
+	})
+}
+
+// serveStoredFile writes the stored file name, calling missing when it doesn't exist
+func serveStoredFile(ctx context.Context, c *app.RequestContext, name string, missing app.HandlerFunc) {
+	data, err := store.Read(name)
+	if name == "." || name == "/" || err == errNotFound {
+		missing(ctx, c)
+		return
+	}
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to read file",
+		})
+		return
+	}
+	writeRange(c, storedContentType(name), data)
+}
+
+// writeRange writes data as the response body, or the part of it a Range
+// header asks for with 206 and Content-Range. A range outside the data gets
+// 416. Requests for several ranges get the whole body.
+func writeRange(c *app.RequestContext, contentType string, data []byte) {
+	c.Header("Accept-Ranges", "bytes")
+	byteRange := c.Request.Header.Peek("Range")
+	if len(byteRange) == 0 || isMultiRange(c) {
+		c.Data(consts.StatusOK, contentType, data)
+		return
+	}
+	start, end, err := app.ParseByteRange(byteRange, len(data))
+	if err != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+		respond(c, consts.StatusRequestedRangeNotSatisfiable, map[string]interface{}{
+			"error": "Requested range not satisfiable",
+		})
+		return
+	}
+	c.Response.Header.SetContentRange(start, end, len(data))
+	c.Data(consts.StatusPartialContent, contentType, data[start:end+1])
+}
+
+// isMultiRange reports whether the request's Range header lists several ranges
+func isMultiRange(c *app.RequestContext) bool {
+	return bytes.IndexByte(c.Request.Header.Peek("Range"), ',') >= 0
+}
+
+// storedContentType returns the MIME type for a stored file from its extension
+func storedContentType(name string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
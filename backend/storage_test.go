@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	mem := newMemoryStorage()
+	data := []byte("contents")
+	if err := mem.Save("b.png", data); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 'X' // the stored copy must not change
+	if err := mem.Save("a.png", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := mem.Read("b.png"); err != nil || string(got) != "contents" {
+		t.Errorf("Read = %q, %v; want %q", got, err, "contents")
+	}
+	if _, err := mem.Read("missing.png"); err != errNotFound {
+		t.Errorf("Read of a missing file = %v, want errNotFound", err)
+	}
+	if ok, _ := mem.Exists("a.png"); !ok {
+		t.Error("Exists(a.png) = false")
+	}
+	assertStored(t, mem, "a.png", "b.png")
+
+	if err := mem.Delete("a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Delete("a.png"); err != errNotFound {
+		t.Errorf("second Delete = %v, want errNotFound", err)
+	}
+	if mem.Len() != 1 {
+		t.Errorf("Len = %d, want 1", mem.Len())
+	}
+	mem.Reset()
+	assertStored(t, mem)
+}
+
+func TestUploadStoresInMemory(t *testing.T) {
+	mem := setupTestServer(t, nil)
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+
+	data := testPNG(t, 64, 48, 255)
+	w := postImage(engine, "/upload", "photo.png", data)
+	if w.Code != consts.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	result := decodeJSON(t, w)
+	filename, _ := result["filename"].(string)
+	assertStored(t, mem, filename)
+	stored, err := mem.Read(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sniffFormat(stored) != "png" {
+		t.Errorf("stored file is %q, want png", sniffFormat(stored))
+	}
+
+	// A rejected upload stores nothing
+	mem.Reset()
+	w = postImage(engine, "/upload", "junk.png", []byte("not an image"))
+	if w.Code != consts.StatusBadRequest {
+		t.Errorf("status of a non-image = %d, want 400", w.Code)
+	}
+	assertStored(t, mem)
+}
+
+func TestHandleStoredFile(t *testing.T) {
+	mem := setupTestServer(t, nil)
+	engine := newTestEngine()
+	engine.GET("/uploads/*filepath", handleStoredFile)
+	data := testPNG(t, 8, 8, 255)
+	mem.Save("a.png", data)
+
+	r := performGet(engine, "/uploads/a.png")
+	if r.Code != consts.StatusOK || !bytes.Equal(r.Body.Bytes(), data) {
+		t.Errorf("GET = %d with %d bytes, want 200 with the stored file", r.Code, r.Body.Len())
+	}
+	if r := performGet(engine, "/uploads/missing.png"); r.Code != consts.StatusNotFound {
+		t.Errorf("GET of a missing file = %d, want 404", r.Code)
+	}
+}
+
+func TestDiskFileHandlerMissingDirectory(t *testing.T) {
+	setupTestServer(t, nil)
+	dir := filepath.Join(t.TempDir(), "uploads")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := testPNG(t, 8, 8, 255)
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	engine := newTestEngine()
+	engine.GET("/uploads/*filepath", newDiskFileHandler(dir))
+
+	if r := performGet(engine, "/uploads/a.png"); r.Code != consts.StatusOK || !bytes.Equal(r.Body.Bytes(), data) {
+		t.Fatalf("GET = %d with %d bytes, want 200 with the stored file", r.Code, r.Body.Len())
+	}
+
+	// The static file server may still hold the file open; it must not be served
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "missing.png"} {
+		if r := performGet(engine, "/uploads/"+name); r.Code != consts.StatusNotFound {
+			t.Errorf("GET %s after removing the directory = %d, want 404", name, r.Code)
+		}
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("uploads directory wasn't recreated: %v", err)
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	setupTestServer(t, nil)
+	data := testPNG(t, 32, 32, 255)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("a.png", data); err != nil {
+		t.Fatal(err)
+	}
+	size := len(data)
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{"prefix", "bytes=0-9", consts.StatusPartialContent, fmt.Sprintf("bytes 0-9/%d", size), data[:10]},
+		{"middle", "bytes=8-15", consts.StatusPartialContent, fmt.Sprintf("bytes 8-15/%d", size), data[8:16]},
+		{"suffix", "bytes=-4", consts.StatusPartialContent, fmt.Sprintf("bytes %d-%d/%d", size-4, size-1, size), data[size-4:]},
+		{"open-ended", fmt.Sprintf("bytes=%d-", size-6), consts.StatusPartialContent, fmt.Sprintf("bytes %d-%d/%d", size-6, size-1, size), data[size-6:]},
+		{"unsatisfiable", fmt.Sprintf("bytes=%d-", size+10), consts.StatusRequestedRangeNotSatisfiable, fmt.Sprintf("bytes */%d", size), nil},
+		{"multiple ranges", "bytes=0-1,4-5", consts.StatusOK, "", data},
+		{"no range", "", consts.StatusOK, "", data},
+	}
+	handlers := map[string]app.HandlerFunc{
+		"memory": handleStoredFile,
+		"disk":   newDiskFileHandler(dir),
+	}
+	for backend, handler := range handlers {
+		engine := newTestEngine()
+		engine.GET("/uploads/*filepath", handler)
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				var headers []ut.Header
+				if tt.rangeHeader != "" {
+					headers = append(headers, ut.Header{Key: "Range", Value: tt.rangeHeader})
+				}
+				r := performGet(engine, "/uploads/a.png", headers...)
+				if r.Code != tt.status {
+					t.Fatalf("status = %d, want %d", r.Code, tt.status)
+				}
+				if got := r.Header().Get("Accept-Ranges"); got != "bytes" {
+					t.Errorf("Accept-Ranges = %q, want bytes", got)
+				}
+				if got := r.Header().Get("Content-Range"); got != tt.contentRange {
+					t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+				}
+				if tt.body != nil && !bytes.Equal(r.Body.Bytes(), tt.body) {
+					t.Errorf("body is %d bytes, want %d", r.Body.Len(), len(tt.body))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/h2non/bimg"
+)
+
+const (
+	// skewSampleSize is the longest side of the thumbnail skew is measured on
+	skewSampleSize = 800
+	// maxSkewAngle is the largest tilt, in degrees, that is corrected
+	maxSkewAngle = 10.0
+	// minSkewAngle is the smallest tilt worth a re-encode
+	minSkewAngle = 0.2
+	// maxStraightenPixels bounds the images rotated, as rotation holds two
+	// uncompressed copies in memory
+	maxStraightenPixels = 25_000_000
+
+	// An image looks like a document when its mean brightness (0-255) is at
+	// least documentBrightness and between minInkFraction and maxInkFraction
+	// of its pixels are dark. The detected angle must sharpen the text lines
+	// by minSkewGain over leaving the image as it is.
+	documentBrightness = 160
+	inkLuma            = 128
+	minInkFraction     = 0.002
+	maxInkFraction     = 0.25
+	minSkewGain        = 1.2
+)
+
+// scanFormats are the formats documents are scanned to. Straightening and
+// trimming leave other formats, such as animated GIFs, alone.
+var scanFormats = map[bimg.ImageType]bool{
+	bimg.JPEG: true,
+	bimg.PNG:  true,
+	bimg.WEBP: true,
+	bimg.TIFF: true,
+}
+
+// straightenImage deskews a slightly tilted document scan for
+// ?autostraighten=true. It is best-effort: images that don't look like
+// documents, whose tilt can't be measured confidently, or that fail to
+// rotate are returned unchanged. A straightened image is re-encoded in its
+// own format, without its metadata, before being compressed as usual.
+func straightenImage(ctx context.Context, data []byte, opts uploadOptions) []byte {
+	format := bimg.DetermineImageType(data)
+	if !scanFormats[format] || !canSave(format) {
+		return data
+	}
+	if dims, err := bimg.Size(data); err != nil || dims.Width*dims.Height > maxStraightenPixels {
+		return data
+	}
+	angle, ok, err := detectSkew(data, opts.NoAutoRotate)
+	if err != nil {
+		hlog.CtxWarnf(ctx, "autostraighten: failed to measure skew: %v", err)
+		return data
+	}
+	if !ok {
+		return data
+	}
+	straightened, err := rotateImage(data, format, angle, opts.NoAutoRotate)
+	if err != nil {
+		hlog.CtxWarnf(ctx, "autostraighten: failed to rotate by %.2f degrees: %v", angle, err)
+		return data
+	}
+	hlog.CtxInfof(ctx, "autostraighten: rotated by %.2f degrees", angle)
+	return straightened
+}
+
+// detectSkew measures the tilt of a document's text lines, in degrees
+// clockwise, from the horizontal projection profile of its dark pixels: at
+// the right angle the rows through text lines and the gaps between them
+// separate most sharply. It reports false for images that don't look like
+// documents or show no clear tilt.
+func detectSkew(data []byte, noAutoRotate bool) (float64, bool, error) {
+	o := bimg.Options{Type: bimg.PNG, Interpretation: bimg.InterpretationBW, NoAutoRotate: noAutoRotate}
+	if dims, err := bimg.Size(data); err == nil && dims.Height > dims.Width {
+		o.Height = skewSampleSize
+	} else {
+		o.Width = skewSampleSize
+	}
+	small, err := bimg.NewImage(data).Process(o)
+	if err != nil {
+		return 0, false, err
+	}
+	img, err := png.Decode(bytes.NewReader(small))
+	if err != nil {
+		return 0, false, err
+	}
+
+	var xs, ys []float64
+	var lumaSum float64
+	b := img.Bounds()
+	cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			luma := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+			lumaSum += float64(luma)
+			if luma < inkLuma {
+				xs = append(xs, float64(x-b.Min.X)-cx)
+				ys = append(ys, float64(y-b.Min.Y)-cy)
+			}
+		}
+	}
+	pixels := float64(b.Dx() * b.Dy())
+	ink := float64(len(xs)) / pixels
+	if pixels == 0 || lumaSum/pixels < documentBrightness || ink < minInkFraction || ink > maxInkFraction {
+		return 0, false, nil
+	}
+
+	// Search coarsely over the whole range, then finely around the best angle
+	rows := int(math.Hypot(cx, cy))*2 + 2
+	best, bestScore := 0.0, profileScore(xs, ys, 0, rows)
+	flat := bestScore
+	search := func(from, to, step float64) {
+		for angle := from; angle <= to+step/2; angle += step {
+			if score := profileScore(xs, ys, angle, rows); score > bestScore {
+				best, bestScore = angle, score
+			}
+		}
+	}
+	search(-maxSkewAngle, maxSkewAngle, 0.5)
+	search(best-0.5, best+0.5, 0.05)
+
+	if math.Abs(best) < minSkewAngle || bestScore < flat*minSkewGain {
+		return 0, false, nil
+	}
+	return best, true, nil
+}
+
+// profileScore is the sum of squared dark-pixel counts per row after
+// rotating the points by -angle degrees, which peaks when text lines are level
+func profileScore(xs, ys []float64, angle float64, rows int) float64 {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	counts := make([]float64, rows)
+	for i := range xs {
+		row := int(math.Round(ys[i]*cos-xs[i]*sin)) + rows/2
+		if row >= 0 && row < rows {
+			counts[row]++
+		}
+	}
+	var score float64
+	for _, n := range counts {
+		score += n * n
+	}
+	return score
+}
+
+// rotateImage rotates an image counter-clockwise by angle degrees about its
+// centre, keeping its size, and re-encodes it as format. Corners uncovered
+// by the rotation are filled white, or left transparent for images with an
+// alpha channel.
+func rotateImage(data []byte, format bimg.ImageType, angle float64, noAutoRotate bool) ([]byte, error) {
+	full, err := bimg.NewImage(data).Process(bimg.Options{Type: bimg.PNG, NoAutoRotate: noAutoRotate})
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := png.Decode(bytes.NewReader(full))
+	if err != nil {
+		return nil, err
+	}
+	b := decoded.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), decoded, b.Min, draw.Src)
+
+	fill := color.NRGBA{255, 255, 255, 255}
+	if hasAlpha(bimg.NewImage(data)) {
+		fill = color.NRGBA{}
+	}
+	dst := image.NewNRGBA(src.Bounds())
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	cx, cy := float64(w-1)/2, float64(h-1)/2
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	for y := 0; y < h; y++ {
+		dy := float64(y) - cy
+		for x := 0; x < w; x++ {
+			dx := float64(x) - cx
+			dst.SetNRGBA(x, y, sampleBilinear(src, cx+dx*cos-dy*sin, cy+dx*sin+dy*cos, fill))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	if format == bimg.PNG {
+		return buf.Bytes(), nil
+	}
+	return bimg.NewImage(buf.Bytes()).Process(bimg.Options{Type: format, Quality: 95})
+}
+
+// sampleBilinear interpolates img at a fractional position, treating pixels
+// outside it as fill
+func sampleBilinear(img *image.NRGBA, x, y float64, fill color.NRGBA) color.NRGBA {
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+	at := func(px, py int) color.NRGBA {
+		if !(image.Point{px, py}.In(img.Rect)) {
+			return fill
+		}
+		return img.NRGBAAt(px, py)
+	}
+	c00, c10, c01, c11 := at(x0, y0), at(x0+1, y0), at(x0, y0+1), at(x0+1, y0+1)
+	mix := func(a, b, c, d uint8) uint8 {
+		top := float64(a)*(1-fx) + float64(b)*fx
+		bottom := float64(c)*(1-fx) + float64(d)*fx
+		return uint8(math.Round(top*(1-fy) + bottom*fy))
+	}
+	return color.NRGBA{
+		R: mix(c00.R, c10.R, c01.R, c11.R),
+		G: mix(c00.G, c10.G, c01.G, c11.G),
+		B: mix(c00.B, c10.B, c01.B, c11.B),
+		A: mix(c00.A, c10.A, c01.A, c11.A),
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// formatSupport records which image types this libvips build can load and
+// save. It is probed once at startup, before any request is served, so a
+// missing format is rejected up front instead of failing deep in processing.
+var formatSupport = struct {
+	load map[bimg.ImageType]bool
+	save map[bimg.ImageType]bool
+}{}
+
+// configureVips applies the libvips cache limits and warns when the worker
+// pool and libvips' own threads together ask for more threads than there
+// are CPUs, which slows every image down instead of adding throughput
+func configureVips() {
+	bimg.VipsCacheSetMax(cfg.VipsCacheMax)
+	bimg.VipsCacheSetMaxMem(cfg.VipsCacheMaxMem)
+	threads := cfg.ProcessingWorkers * cfg.VipsConcurrency
+	hlog.Infof("libvips: %d threads per operation, %d processing workers (up to %d threads), cache of %d operations or %d bytes",
+		cfg.VipsConcurrency, cfg.ProcessingWorkers, threads, cfg.VipsCacheMax, cfg.VipsCacheMaxMem)
+	if cpus := runtime.NumCPU(); threads > cpus {
+		hlog.Warnf("libvips: PROCESSING_WORKERS x VIPS_CONCURRENCY = %d threads on %d CPUs; lower one of them to avoid oversubscription", threads, cpus)
+	}
+}
+
+// probeFormats fills formatSupport and logs what the build supports
+func probeFormats() {
+	formatSupport.load = make(map[bimg.ImageType]bool)
+	formatSupport.save = make(map[bimg.ImageType]bool)
+	var loaders, savers []string
+	for format, name := range bimg.ImageTypes {
+		if format == bimg.UNKNOWN {
+			continue
+		}
+		if bimg.IsTypeSupported(format) {
+			formatSupport.load[format] = true
+			loaders = append(loaders, name)
+		}
+		if bimg.IsTypeSupportedSave(format) {
+			formatSupport.save[format] = true
+			savers = append(savers, name)
+		}
+	}
+	sort.Strings(loaders)
+	sort.Strings(savers)
+	hlog.Infof("libvips formats: load %s; save %s", strings.Join(loaders, ", "), strings.Join(savers, ", "))
+}
+
+// canLoad reports whether this build can decode format
+func canLoad(format bimg.ImageType) bool {
+	return formatSupport.load[format]
+}
+
+// canSave reports whether this build can encode format
+func canSave(format bimg.ImageType) bool {
+	return formatSupport.save[format]
+}
+
+// unsupportedFormat is the error for a format missing from this build
+func unsupportedFormat(format bimg.ImageType) error {
+	return &httpError{consts.StatusUnsupportedMediaType, fmt.Sprintf("format %s not supported by this build", bimg.ImageTypeName(format))}
+}
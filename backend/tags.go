@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// Limits on the tags of one image, which are stored in its sidecar record
+const (
+	maxTags           = 64
+	maxTagKeyLength   = 64
+	maxTagValueLength = 1024
+)
+
+// parseTags reads a JSON object of string tags, such as
+// {"caption": "Sunset", "album": "holiday"}
+func parseTags(body []byte) (map[string]string, error) {
+	var tags map[string]string
+	if err := json.Unmarshal(body, &tags); err != nil || tags == nil {
+		return nil, &httpError{consts.StatusBadRequest, "Request body must be a JSON object of string tags"}
+	}
+	if len(tags) > maxTags {
+		return nil, &httpError{consts.StatusBadRequest, fmt.Sprintf("Too many tags: at most %d per image", maxTags)}
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var errs validationErrors
+	for _, key := range keys {
+		value := tags[key]
+		if key == "" || len(key) > maxTagKeyLength || strings.Contains(key, ":") {
+			errs.add(&httpError{consts.StatusBadRequest, fmt.Sprintf("Invalid tag name %q: must be 1-%d characters without a colon", key, maxTagKeyLength)})
+		}
+		if len(value) > maxTagValueLength {
+			errs.add(&httpError{consts.StatusBadRequest, fmt.Sprintf("Tag %q is too long: at most %d characters", key, maxTagValueLength)})
+		}
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// lookupStoredFile checks that filename names a stored file, responding with
+// the error and returning false when it doesn't
+func lookupStoredFile(c *app.RequestContext, filename string) bool {
+	if !validStoredName(filename) {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Invalid filename",
+		})
+		return false
+	}
+	exists, err := store.Exists(filename)
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to look up file",
+		})
+		return false
+	}
+	if !exists {
+		respond(c, consts.StatusNotFound, map[string]interface{}{
+			"error": "File not found",
+		})
+		return false
+	}
+	return true
+}
+
+// handleGetImageMeta returns the tags of a stored image
+func handleGetImageMeta(ctx context.Context, c *app.RequestContext) {
+	filename := c.Param("filename")
+	if !lookupStoredFile(c, filename) {
+		return
+	}
+	record, _, err := meta.Get(filename)
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to read upload metadata",
+		})
+		return
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"filename": filename,
+		"tags":     tagsOrEmpty(record.Tags),
+	})
+}
+
+// handlePutImageMeta replaces the tags of a stored image with the JSON
+// object in the request body. An empty object removes them all. Like a
+// replacement, it needs the file's deletion token or the admin token.
+func handlePutImageMeta(ctx context.Context, c *app.RequestContext) {
+	filename := c.Param("filename")
+	tags, err := parseTags(c.Request.Body())
+	if err != nil {
+		respond(c, errorStatus(err), errorResponse(err))
+		return
+	}
+
+	unlock := filenameLocks.Lock(filename)
+	defer unlock()
+	if !lookupStoredFile(c, filename) {
+		return
+	}
+	record, _, err := meta.Get(filename)
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to read upload metadata",
+		})
+		return
+	}
+	if !mayModifyStoredFile(c, record) {
+		respond(c, consts.StatusForbidden, map[string]interface{}{
+			"error": "Editing tags needs the file's deletion token or the admin token",
+		})
+		return
+	}
+	record.Tags = tags
+	if len(tags) == 0 {
+		record.Tags = nil
+	}
+	if err := meta.Put(filename, record); err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to save upload metadata",
+		})
+		return
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"filename": filename,
+		"tags":     tagsOrEmpty(record.Tags),
+	})
+}
+
+// tagFilter matches images having a tag, or a tag with a given value
+type tagFilter struct {
+	key, value string
+	anyValue   bool
+}
+
+// parseTagFilter parses a tag query parameter: "album" matches images with
+// an album tag, "album:holiday" only those whose album is holiday
+func parseTagFilter(v string) tagFilter {
+	if i := strings.IndexByte(v, ':'); i >= 0 {
+		return tagFilter{key: v[:i], value: v[i+1:]}
+	}
+	return tagFilter{key: v, anyValue: true}
+}
+
+// matches reports whether tags satisfy the filter
+func (f tagFilter) matches(tags map[string]string) bool {
+	value, ok := tags[f.key]
+	return ok && (f.anyValue || value == f.value)
+}
+
+// handleListImages lists the stored images with their tags, sorted by
+// filename. Each tag query parameter narrows the list further.
+// Uploads still pending a commit aren't listed.
+func handleListImages(ctx context.Context, c *app.RequestContext) {
+	var filters []tagFilter
+	for _, v := range c.QueryArgs().PeekAll("tag") {
+		filters = append(filters, parseTagFilter(string(v)))
+	}
+
+	files, err := store.List()
+	if err != nil {
+		respond(c, consts.StatusInternalServerError, map[string]interface{}{
+			"error": "Failed to list files",
+		})
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	images := make([]map[string]interface{}, 0, len(files))
+	// With ASSETS=true the files of one asset are listed as a single entry,
+	// where the first of them to match is listed, with all of them as variants
+	grouped := make(map[string]bool)
+	for _, file := range files {
+		record, _, err := meta.Get(file.Name)
+		if err != nil {
+			respond(c, consts.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to read upload metadata",
+			})
+			return
+		}
+		if record.Pending || !matchesAll(filters, record.Tags) {
+			continue
+		}
+		entry := map[string]interface{}{
+			"filename": file.Name,
+			"url":      versionedFileURL(ctx, file.Name, record.SHA256),
+			"size":     file.Size,
+			"modified": file.ModTime.UTC(),
+			"tags":     tagsOrEmpty(record.Tags),
+		}
+		if id, ok := assets.AssetOf(file.Name); ok && cfg.Assets {
+			if grouped[id] {
+				continue
+			}
+			grouped[id] = true
+			if a, ok := assets.Get(id); ok {
+				entry["asset"] = id
+				entry["variants"] = variantsResponse(ctx, a.Variants)
+			}
+		}
+		images = append(images, entry)
+	}
+	respond(c, consts.StatusOK, map[string]interface{}{
+		"images": images,
+	})
+}
+
+// matchesAll reports whether tags satisfy every filter
+func matchesAll(filters []tagFilter, tags map[string]string) bool {
+	for _, f := range filters {
+		if !f.matches(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagsOrEmpty returns tags, or an empty map so responses always carry an object
+func tagsOrEmpty(tags map[string]string) map[string]string {
+	if tags == nil {
+		return map[string]string{}
+	}
+	return tags
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/common/ut"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+func TestPutImageMetaNeedsToken(t *testing.T) {
+	setupTestServer(t, map[string]string{"DELETE_TOKENS": "true", "ADMIN_TOKEN": "admin-secret"})
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+	engine.PUT("/images/:filename/meta", handlePutImageMeta)
+
+	w := postImage(engine, "/upload", "photo.png", testPNG(t, 64, 48, 255))
+	if w.Code != consts.StatusOK {
+		t.Fatalf("upload status = %d: %s", w.Code, w.Body.String())
+	}
+	uploaded := decodeJSON(t, w)
+	filename, _ := uploaded["filename"].(string)
+	token, _ := uploaded["delete_token"].(string)
+
+	tests := []struct {
+		name    string
+		query   string
+		headers []ut.Header
+		status  int
+	}{
+		{"no token", "", nil, consts.StatusForbidden},
+		{"wrong token", "?token=wrong", nil, consts.StatusForbidden},
+		{"wrong admin token", "", []ut.Header{{Key: "Authorization", Value: "Bearer wrong"}}, consts.StatusForbidden},
+		{"deletion token", "?token=" + token, nil, consts.StatusOK},
+		{"admin token", "", []ut.Header{{Key: "Authorization", Value: "Bearer admin-secret"}}, consts.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte(`{"caption": "` + tt.name + `"}`)
+			w := ut.PerformRequest(engine, "PUT", "/images/"+filename+"/meta"+tt.query,
+				&ut.Body{Body: bytes.NewReader(body), Len: len(body)}, tt.headers...)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			record, _, _ := meta.Get(filename)
+			if changed := record.Tags["caption"] == tt.name; changed != (tt.status == consts.StatusOK) {
+				t.Errorf("tags changed = %v with status %d", changed, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// setupTempDir checks that dir is a writable directory and makes it the
+// process temp directory. Setting TMPDIR routes Go's multipart spill files
+// (large uploads that don't fit in memory) and libvips's temporary files
+// there as well, not just this service's own.
+func setupTempDir(dir string) error {
+	if err := checkWritableDir(dir); err != nil {
+		return fmt.Errorf("invalid TEMP_DIR: %v", err)
+	}
+	return os.Setenv("TMPDIR", dir)
+}
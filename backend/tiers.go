@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// defaultTargetSize is the compression target used when no tier matches
+const defaultTargetSize = 1024 * 1024 // 1MB in bytes
+
+// ON_SIZE_EXCEEDED values: what happens to images that can't be compressed
+// under their target
+const (
+	sizeExceededReject      = "reject"       // refuse the upload with 422
+	sizeExceededStoreAnyway = "store-anyway" // keep the smallest attempt
+)
+
+// uploadTarget returns the compression target for an uploaded image
+func uploadTarget(data []byte, opts uploadOptions) int {
+	width, height := 0, 0
+	if dims, err := bimg.Size(data); err == nil {
+		width, height = dims.Width, dims.Height
+	}
+	return capTarget(compressionTarget(cfg.CompressionTiers, len(data), width, height), opts)
+}
+
+// capTarget lowers a tier's target to the upload's ?max_bytes=. It can't
+// raise it: the tiers are the most any upload may be stored at.
+func capTarget(target int, opts uploadOptions) int {
+	if opts.MaxBytes > 0 && opts.MaxBytes < target {
+		return opts.MaxBytes
+	}
+	return target
+}
+
+// parseMaxBytes parses ?max_bytes=, a byte size such as 500KB or 512000
+func parseMaxBytes(v string) (int, error) {
+	n, err := parseByteSize(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("max_bytes must be a positive byte size such as 500KB")
+	}
+	return n, nil
+}
+
+// compressionTier maps originals up to a given size to a compression target.
+// The limit is either a byte count or, when byPixels is set, the longest
+// side of the original image in pixels.
+type compressionTier struct {
+	limit    int
+	byPixels bool
+	target   int
+}
+
+// matches reports whether an original of the given byte size and dimensions
+// falls in the tier. Pixel tiers never match an image whose dimensions
+// couldn't be read, rather than treating it as small.
+func (t compressionTier) matches(size, width, height int) bool {
+	if t.byPixels {
+		if width == 0 || height == 0 {
+			return false
+		}
+		longest := width
+		if height > longest {
+			longest = height
+		}
+		return longest <= t.limit
+	}
+	return size <= t.limit
+}
+
+// parseCompressionTiers parses a comma-separated list of "<limit>:<target>" tiers.
+// The limit is a byte size ("2MB") or a longest-side pixel count ("1920px"),
+// and the target is a byte size. For example: "2MB:256KB,1920px:512KB".
+func parseCompressionTiers(s string) ([]compressionTier, error) {
+	var tiers []compressionTier
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("tier %q must be <limit>:<target>", entry)
+		}
+
+		var tier compressionTier
+		limit := strings.ToLower(strings.TrimSpace(parts[0]))
+		if strings.HasSuffix(limit, "px") {
+			n, err := strconv.Atoi(strings.TrimSuffix(limit, "px"))
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("tier %q has an invalid pixel limit", entry)
+			}
+			tier.limit, tier.byPixels = n, true
+		} else {
+			n, err := parseByteSize(limit)
+			if err != nil {
+				return nil, fmt.Errorf("tier %q: %v", entry, err)
+			}
+			tier.limit = n
+		}
+
+		target, err := parseByteSize(parts[1])
+		if err != nil || target <= 0 {
+			return nil, fmt.Errorf("tier %q has an invalid target", entry)
+		}
+		tier.target = target
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+// compressionTarget picks the target size for an original image.
+// Tiers are checked in the order they were configured and the first
+// matching tier wins; when none match, defaultTargetSize is used.
+func compressionTarget(tiers []compressionTier, size, width, height int) int {
+	for _, tier := range tiers {
+		if tier.matches(size, width, height) {
+			return tier.target
+		}
+	}
+	return defaultTargetSize
+}
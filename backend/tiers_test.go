@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestParseCompressionTiers(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []compressionTier
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"512KB:256KB", []compressionTier{{limit: 512 * 1024, target: 256 * 1024}}, false},
+		{" 2MB:1MB , 1920px:512KB ", []compressionTier{
+			{limit: 2 * 1024 * 1024, target: 1024 * 1024},
+			{limit: 1920, byPixels: true, target: 512 * 1024},
+		}, false},
+		{"512KB", nil, true},
+		{"0px:1MB", nil, true},
+		{"abcpx:1MB", nil, true},
+		{"1MB:0", nil, true},
+		{"1MB:lots", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCompressionTiers(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCompressionTiers(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseCompressionTiers(%q) = %+v, want %+v", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseCompressionTiers(%q)[%d] = %+v, want %+v", tt.in, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestCompressionTarget(t *testing.T) {
+	tiers, err := parseCompressionTiers("512KB:256KB,1920px:512KB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name                string
+		size, width, height int
+		want                int
+	}{
+		{"small file", 300 * 1024, 4000, 3000, 256 * 1024},
+		{"byte limit is inclusive", 512 * 1024, 4000, 3000, 256 * 1024},
+		{"large file, small dimensions", 3 * 1024 * 1024, 1600, 1200, 512 * 1024},
+		{"portrait uses the longest side", 3 * 1024 * 1024, 1200, 1920, 512 * 1024},
+		{"matches no tier", 3 * 1024 * 1024, 4000, 3000, defaultTargetSize},
+		{"unknown dimensions skip pixel tiers", 3 * 1024 * 1024, 0, 0, defaultTargetSize},
+	}
+	for _, tt := range tests {
+		if got := compressionTarget(tiers, tt.size, tt.width, tt.height); got != tt.want {
+			t.Errorf("%s: compressionTarget = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := compressionTarget(nil, 10, 10, 10); got != defaultTargetSize {
+		t.Errorf("without tiers: compressionTarget = %d, want %d", got, defaultTargetSize)
+	}
+}
+
+func TestCapTarget(t *testing.T) {
+	tests := []struct {
+		target, maxBytes, want int
+	}{
+		{1024, 0, 1024},
+		{1024, 512, 512},
+		{1024, 4096, 1024}, // max_bytes can't raise a tier's target
+	}
+	for _, tt := range tests {
+		if got := capTarget(tt.target, uploadOptions{MaxBytes: tt.maxBytes}); got != tt.want {
+			t.Errorf("capTarget(%d, max_bytes=%d) = %d, want %d", tt.target, tt.maxBytes, got, tt.want)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"512", 512, false},
+		{"256KB", 256 * 1024, false},
+		{"1mb", 1024 * 1024, false},
+		{"2GB", 2 * 1024 * 1024 * 1024, false},
+		{"-1KB", 0, true},
+		{"lots", 0, true},
+		// 2^34+1 GB wraps around to 1GB without the overflow check
+		{"17179869185GB", 0, true},
+		{fmt.Sprintf("%dKB", math.MaxInt/1024+1), 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
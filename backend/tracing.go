@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the request and processing spans. Until setupTracing
+// installs an exporter it is OpenTelemetry's no-op tracer.
+var tracer = otel.Tracer("my-backend")
+
+// tracingEnabled reports whether an OTLP endpoint is configured, through the
+// standard OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+func tracingEnabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// setupTracing exports spans over OTLP/HTTP when tracing is enabled. The
+// exporter reads the other standard OTEL_* variables itself (headers,
+// timeout, TLS), and OTEL_SERVICE_NAME names the service. The returned
+// function flushes pending spans on shutdown.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if !tracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := resource.Default()
+	if os.Getenv("OTEL_SERVICE_NAME") == "" {
+		if res, err = resource.Merge(res, resource.NewSchemaless(semconv.ServiceName("my-backend"))); err != nil {
+			return nil, err
+		}
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tracer = provider.Tracer("my-backend")
+	return provider.Shutdown, nil
+}
+
+// headerCarrier adapts Hertz request headers for trace context propagation
+type headerCarrier struct {
+	header *protocol.RequestHeader
+}
+
+func (h headerCarrier) Get(key string) string {
+	return string(h.header.Peek(key))
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h.header.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	var keys []string
+	h.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// tracingMiddleware wraps each request in a span, continuing the trace of an
+// incoming traceparent header. Handlers get the span's context, so their
+// processing spans become its children.
+func tracingMiddleware(ctx context.Context, c *app.RequestContext) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier{&c.Request.Header})
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	method := string(c.Method())
+	ctx, span := tracer.Start(ctx, method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethod(method),
+			semconv.HTTPRoute(route),
+			semconv.HTTPTarget(string(c.Request.RequestURI())),
+			semconv.HTTPClientIP(c.ClientIP()),
+		),
+	)
+	defer span.End()
+
+	c.Next(ctx)
+
+	status := c.Response.StatusCode()
+	span.SetAttributes(semconv.HTTPStatusCode(status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, "")
+	}
+}
+
+// startSpan starts a processing span under the request's span
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
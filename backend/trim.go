@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"image/png"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/h2non/bimg"
+)
+
+// trimImage removes the uniform border around an image for ?trim=true, as
+// left by scanners, and returns the trimmed image in its own format with
+// its new size. The border colour is the top-left pixel's, and pixels
+// within TRIM_THRESHOLD of it count as border. Images without a border, or
+// that are nothing but border, are returned unchanged with a nil size.
+func trimImage(ctx context.Context, data []byte) ([]byte, *bimg.ImageSize) {
+	format := bimg.DetermineImageType(data)
+	if !scanFormats[format] || !canSave(format) {
+		return data, nil
+	}
+	before, err := bimg.Size(data)
+	if err != nil {
+		return data, nil
+	}
+	background, err := cornerColor(data)
+	if err != nil {
+		hlog.CtxWarnf(ctx, "trim: failed to read the border colour: %v", err)
+		return data, nil
+	}
+
+	trimmed, err := bimg.NewImage(data).Process(bimg.Options{
+		Trim:       true,
+		Background: background,
+		Threshold:  cfg.TrimThreshold,
+		Type:       format,
+		Quality:    95,
+	})
+	if err != nil {
+		// libvips can't extract an empty area from an image that is all border
+		hlog.CtxDebugf(ctx, "trim: nothing trimmed: %v", err)
+		return data, nil
+	}
+	after, err := bimg.Size(trimmed)
+	if err != nil || after == before {
+		return data, nil
+	}
+	return trimmed, &after
+}
+
+// cornerColor returns the colour of an image's top-left pixel
+func cornerColor(data []byte) (bimg.Color, error) {
+	corner, err := bimg.NewImage(data).Process(bimg.Options{
+		AreaWidth:  1,
+		AreaHeight: 1,
+		Type:       bimg.PNG,
+	})
+	if err != nil {
+		return bimg.Color{}, err
+	}
+	img, err := png.Decode(bytes.NewReader(corner))
+	if err != nil {
+		return bimg.Color{}, err
+	}
+	b := img.Bounds()
+	c := color.NRGBAModel.Convert(img.At(b.Min.X, b.Min.Y)).(color.NRGBA)
+	return bimg.Color{R: c.R, G: c.G, B: c.B}, nil
+}
+
+// addTrimmed adds the trimmed size to an upload result when ?trim=true
+// removed a border
+func (d processDetails) addTrimmed(result map[string]interface{}) {
+	if d.TrimmedSize == nil {
+		return
+	}
+	result["trimmed"] = map[string]interface{}{
+		"width":  d.TrimmedSize.Width,
+		"height": d.TrimmedSize.Height,
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"testing"
+
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// borderedPNG encodes a w x h gradient inside a white border of the given width
+func borderedPNG(t *testing.T, w, h, border int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w+2*border, h+2*border))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(border, border, border+w, border+h), testImage(w, h, 255), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// flatPNG encodes a w x h image of one colour
+func flatPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTrimImage(t *testing.T) {
+	setupTestServer(t, nil)
+	tests := []struct {
+		name    string
+		data    []byte
+		trimmed bool
+		width   int
+		height  int
+	}{
+		{"bordered", borderedPNG(t, 40, 30, 20), true, 40, 30},
+		{"no border", testPNG(t, 40, 30, 255), false, 40, 30},
+		{"all border", flatPNG(t, 40, 30), false, 40, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, size := trimImage(context.Background(), tt.data)
+			if (size != nil) != tt.trimmed {
+				t.Fatalf("trimmed size = %v, want trimmed = %v", size, tt.trimmed)
+			}
+			if !tt.trimmed && !bytes.Equal(out, tt.data) {
+				t.Error("an image with nothing to trim was changed")
+			}
+			dims, err := bimg.Size(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.trimmed && (dims.Width != tt.width || dims.Height != tt.height) {
+				t.Errorf("trimmed to %dx%d, want %dx%d", dims.Width, dims.Height, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestTrimUpload(t *testing.T) {
+	// 40x40 once trimmed falls in the first tier; the 120x120 original wouldn't
+	setupTestServer(t, map[string]string{"COMPRESSION_TIERS": "64px:300KB,10000px:200KB"})
+	engine := newTestEngine()
+	engine.POST("/upload", handleImageUpload)
+
+	w := postImage(engine, "/upload?trim=true&max_bytes=10MB", "scan.png", borderedPNG(t, 40, 40, 40))
+	if w.Code != consts.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	result := decodeJSON(t, w)
+	trimmed, _ := result["trimmed"].(map[string]interface{})
+	if trimmed["width"] != float64(40) || trimmed["height"] != float64(40) {
+		t.Errorf("trimmed = %v, want 40x40", result["trimmed"])
+	}
+	if result["target_size"] != float64(300*1024) {
+		t.Errorf("target_size = %v, want the trimmed image's tier target %d", result["target_size"], 300*1024)
+	}
+}
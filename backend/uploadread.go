@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// readFormFile opens and reads an uploaded file. Large files are spooled to
+// temporary files by the multipart parser, and opening or reading those can
+// fail transiently under load, e.g. when the process is out of file
+// descriptors. Such failures are retried UPLOAD_READ_RETRIES times with a
+// doubling delay; any other failure is returned at once.
+func readFormFile(ctx context.Context, fileHeader *multipart.FileHeader) ([]byte, error) {
+	delay := cfg.UploadReadRetryDelay
+	for attempt := 1; ; attempt++ {
+		data, step, err := readFormFileOnce(fileHeader)
+		if err == nil {
+			return data, nil
+		}
+		failure := &httpError{consts.StatusInternalServerError, "Failed to " + step + " uploaded file"}
+		if !isTransientIOError(err) {
+			hlog.CtxErrorf(ctx, "failed to %s uploaded file %q: %v", step, fileHeader.Filename, err)
+			return nil, failure
+		}
+		if attempt > cfg.UploadReadRetries {
+			hlog.CtxErrorf(ctx, "failed to %s uploaded file %q after %d attempts: %v", step, fileHeader.Filename, attempt, err)
+			return nil, failure
+		}
+		hlog.CtxWarnf(ctx, "transient failure to %s uploaded file %q, retrying in %v: %v", step, fileHeader.Filename, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, failure
+		}
+		delay *= 2
+	}
+}
+
+// readFormFileOnce makes one attempt at reading an uploaded file, naming the
+// step that failed: "open" or "read"
+func readFormFileOnce(fileHeader *multipart.FileHeader) ([]byte, string, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, "open", err
+	}
+	defer file.Close()
+	buffer := bytes.NewBuffer(nil)
+	if _, err := io.Copy(buffer, file); err != nil {
+		return nil, "read", err
+	}
+	return buffer.Bytes(), "", nil
+}
+
+// isTransientIOError reports whether a failed open or read may succeed if
+// tried again: interrupted or timed-out calls, busy resources and exhausted
+// file descriptors. Missing files and permission errors are permanent.
+func isTransientIOError(err error) bool {
+	if os.IsTimeout(err) {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.EINTR, syscall.EAGAIN, syscall.EBUSY, syscall.EMFILE, syscall.ENFILE} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
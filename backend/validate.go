@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+	"github.com/h2non/bimg"
+)
+
+// errTruncated is returned when an image's structure ends before its end marker
+var errTruncated = errors.New("image data is truncated")
+
+// sniffFormat names the image format from the file's magic bytes, or
+// returns "" when the content isn't one of the accepted formats
+func sniffFormat(data []byte) string {
+	switch {
+	case len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF:
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	case len(data) >= 14 && data[0] == 'B' && data[1] == 'M':
+		return "bmp"
+	}
+	return ""
+}
+
+// validateImageData checks that the content really is an image of an accepted
+// format, walks its structure to the format's end marker, and rejects files
+// carrying more than MAX_TRAILING_BYTES after it. Such trailers are how a
+// valid image prefix smuggles another payload (a zip, script or executable)
+// past magic-byte checks, and small images are stored without re-encoding.
+func validateImageData(data []byte) error {
+	format := sniffFormat(data)
+	if format == "" {
+		return &httpError{consts.StatusBadRequest, "Uploaded file is not a valid image"}
+	}
+
+	end, err := imageDataEnd(format, data)
+	if err != nil {
+		return &httpError{consts.StatusBadRequest, fmt.Sprintf("Uploaded %s image is malformed: %v", format, err)}
+	}
+	if trailing := len(data) - end; trailing > cfg.MaxTrailingBytes {
+		return &httpError{consts.StatusBadRequest, fmt.Sprintf("Uploaded image has %d unexpected bytes after the image data", trailing)}
+	}
+	if err := checkDecodes(data); err != nil {
+		return &httpError{consts.StatusBadRequest, fmt.Sprintf("Uploaded %s image is corrupt: %v", format, err)}
+	}
+	return nil
+}
+
+// checkDecodes makes libvips read the header and decode a small thumbnail,
+// which reads the pixel data, so a well-formed structure around corrupt
+// image data is refused too. Formats this build can't load are left to the
+// format support check.
+func checkDecodes(data []byte) error {
+	format := bimg.DetermineImageType(data)
+	if format == bimg.UNKNOWN || !canLoad(format) {
+		return nil
+	}
+	img := bimg.NewImage(data)
+	if _, err := img.Metadata(); err != nil {
+		return err
+	}
+	_, err := img.Process(bimg.Options{Width: 32, Height: 32, Type: bimg.PNG, NoAutoRotate: true})
+	return err
+}
+
+// checkAspectRatio refuses images more elongated than MAX_ASPECT_RATIO in
+// either direction. Only the image header is read.
+func checkAspectRatio(data []byte) error {
+	if cfg.MaxAspectRatio == 0 {
+		return nil
+	}
+	dims, err := bimg.Size(data)
+	if err != nil || dims.Width <= 0 || dims.Height <= 0 {
+		return nil // left to the decoder to reject
+	}
+	long, short := dims.Width, dims.Height
+	if short > long {
+		long, short = short, long
+	}
+	if float64(long) > float64(short)*cfg.MaxAspectRatio {
+		return &httpError{consts.StatusBadRequest, fmt.Sprintf(
+			"Image is too elongated: %dx%d exceeds the maximum aspect ratio of %s:1",
+			dims.Width, dims.Height, strconv.FormatFloat(cfg.MaxAspectRatio, 'f', -1, 64))}
+	}
+	return nil
+}
+
+// imageDataEnd returns the offset just past the end of the image data
+func imageDataEnd(format string, data []byte) (int, error) {
+	switch format {
+	case "jpeg":
+		return jpegDataEnd(data)
+	case "png":
+		return pngDataEnd(data)
+	case "gif":
+		return gifDataEnd(data)
+	case "webp":
+		return riffDataEnd(data)
+	case "bmp":
+		return bmpDataEnd(data)
+	}
+	return len(data), nil
+}
+
+// jpegDataEnd walks JPEG segments and entropy-coded scans up to the EOI marker
+func jpegDataEnd(data []byte) (int, error) {
+	i := 2
+	for {
+		if i+1 >= len(data) {
+			return 0, errTruncated
+		}
+		if data[i] != 0xFF {
+			return 0, fmt.Errorf("expected a marker at offset %d", i)
+		}
+		marker := data[i+1]
+		i += 2
+		switch {
+		case marker == 0xFF:
+			i-- // fill byte before the real marker
+			continue
+		case marker == 0xD9:
+			return i, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			continue // markers without a length
+		}
+
+		if i+1 >= len(data) {
+			return 0, errTruncated
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return 0, errTruncated
+		}
+		i += length
+
+		if marker == 0xDA {
+			// Skip entropy-coded data up to the next marker. 0xFF00 is an
+			// escaped 0xFF and 0xFFD0-0xFFD7 are restart markers.
+			for {
+				if i+1 >= len(data) {
+					return 0, errTruncated
+				}
+				if data[i] == 0xFF {
+					next := data[i+1]
+					if next != 0x00 && next != 0xFF && (next < 0xD0 || next > 0xD7) {
+						break
+					}
+				}
+				i++
+			}
+		}
+	}
+}
+
+// pngDataEnd walks PNG chunks up to and including IEND
+func pngDataEnd(data []byte) (int, error) {
+	i := 8
+	for {
+		if i+8 > len(data) {
+			return 0, errTruncated
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length // length, type, data and CRC
+		if end > len(data) {
+			return 0, errTruncated
+		}
+		if chunkType == "IEND" {
+			return end, nil
+		}
+		i = end
+	}
+}
+
+// gifDataEnd walks GIF blocks up to the trailer byte
+func gifDataEnd(data []byte) (int, error) {
+	end, _, err := walkGIF(data)
+	return end, err
+}
+
+// gifFrameCount returns how many frames a GIF has, or 0 if it is malformed
+func gifFrameCount(data []byte) int {
+	_, frames, err := walkGIF(data)
+	if err != nil {
+		return 0
+	}
+	return frames
+}
+
+// walkGIF walks GIF blocks up to the trailer byte, counting the image
+// descriptors, one per frame
+func walkGIF(data []byte) (end, frames int, err error) {
+	if len(data) < 13 {
+		return 0, 0, errTruncated
+	}
+	i := 13
+	if packed := data[10]; packed&0x80 != 0 {
+		i += 3 << ((packed & 0x07) + 1) // global color table
+	}
+
+	for {
+		if i >= len(data) {
+			return 0, 0, errTruncated
+		}
+		switch data[i] {
+		case 0x3B: // trailer
+			return i + 1, frames, nil
+		case 0x21: // extension: label then sub-blocks
+			if i, err = skipGIFSubBlocks(data, i+2); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // image descriptor, optional local color table, LZW code size, sub-blocks
+			if i+10 > len(data) {
+				return 0, 0, errTruncated
+			}
+			frames++
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << ((packed & 0x07) + 1)
+			}
+			if i, err = skipGIFSubBlocks(data, i+1); err != nil {
+				return 0, 0, err
+			}
+		default:
+			return 0, 0, fmt.Errorf("unknown block 0x%02x at offset %d", data[i], i)
+		}
+	}
+}
+
+// skipGIFSubBlocks skips a sequence of GIF data sub-blocks starting at i
+func skipGIFSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errTruncated
+		}
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+}
+
+// riffDataEnd returns the end of a RIFF container (WebP) from its size header
+func riffDataEnd(data []byte) (int, error) {
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	end := 8 + size + size%2 // chunks are padded to an even length
+	if size < 4 || end > len(data)+size%2 {
+		return 0, errTruncated
+	}
+	if end > len(data) {
+		end = len(data)
+	}
+	return end, nil
+}
+
+// bmpDataEnd returns the end of a BMP file from its size header
+func bmpDataEnd(data []byte) (int, error) {
+	size := int(binary.LittleEndian.Uint32(data[2:]))
+	if size == 0 {
+		return len(data), nil // some writers leave the size unset
+	}
+	if size < 14 || size > len(data) {
+		return 0, errTruncated
+	}
+	return size, nil
+}
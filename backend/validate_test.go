@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// corruptPNGData flips bytes inside the first IDAT chunk, keeping the chunk
+// structure intact so only a decode notices
+func corruptPNGData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	out := append([]byte(nil), data...)
+	i := bytes.Index(out, []byte("IDAT"))
+	if i < 4 {
+		t.Fatal("no IDAT chunk")
+	}
+	length := int(binary.BigEndian.Uint32(out[i-4:]))
+	for j := i + 4 + length/4; j < i+4+length*3/4; j++ {
+		out[j] ^= 0x5A
+	}
+	return out
+}
+
+func TestValidateImageData(t *testing.T) {
+	setupTestServer(t, map[string]string{"MAX_TRAILING_BYTES": "16"})
+	png := testPNG(t, 32, 32, 255)
+	jpeg := testJPEG(t, 32, 32)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		valid bool
+	}{
+		{"png", png, true},
+		{"jpeg", jpeg, true},
+		{"tolerated trailer", append(append([]byte(nil), png...), make([]byte, 16)...), true},
+		{"appended payload", append(append([]byte(nil), png...), []byte("PK\x03\x04 a zip archive follows")...), false},
+		{"truncated png", png[:len(png)-20], false},
+		{"truncated jpeg", jpeg[:len(jpeg)/2], false},
+		{"corrupt pixel data", corruptPNGData(t, png), false},
+		{"not an image", []byte("<html><script>alert(1)</script></html>"), false},
+		{"empty", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateImageData(tt.data)
+			if tt.valid && err != nil {
+				t.Errorf("rejected a valid image: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("accepted an invalid image")
+			}
+			if err != nil && errorStatus(err) != 400 {
+				t.Errorf("status = %d, want 400", errorStatus(err))
+			}
+		})
+	}
+}
+
+func TestSniffFormat(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{[]byte{0xFF, 0xD8, 0xFF, 0xE0}, "jpeg"},
+		{[]byte("\x89PNG\r\n\x1a\n...."), "png"},
+		{[]byte("GIF89a......"), "gif"},
+		{[]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "webp"},
+		{[]byte("BM" + string(make([]byte, 12))), "bmp"},
+		{[]byte("hello"), ""},
+	}
+	for _, tt := range tests {
+		if got := sniffFormat(tt.data); got != tt.want {
+			t.Errorf("sniffFormat(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/h2non/bimg"
+)
+
+// Warning codes for conditions that don't fail a request but that clients
+// may want to tell users about. The codes are stable; the messages may change.
+const (
+	// warnNotUpscaled: the image is smaller than the requested box and was
+	// kept at its own size rather than enlarged
+	warnNotUpscaled = "not_upscaled"
+	// warnSizeTargetExceeded: the stored image is over its size target, e.g.
+	// under ON_SIZE_EXCEEDED=store-anyway
+	warnSizeTargetExceeded = "size_target_exceeded"
+	// warnAnimationFlattened: only the first frame of an animated image was kept
+	warnAnimationFlattened = "animation_flattened"
+	// warnDPINotSet: ?dpi= was given but the output format has no density
+	// metadata it can be recorded in
+	warnDPINotSet = "dpi_not_set"
+)
+
+// processingWarnings compares a processed image with the image it was
+// compressed from, after any trimming, and lists the non-fatal problems, as {"code", "message"} entries, or nil when there
+// are none
+func processingWarnings(data, compressed []byte, opts uploadOptions) []map[string]interface{} {
+	var warnings []map[string]interface{}
+	add := func(code, format string, args ...interface{}) {
+		warnings = append(warnings, map[string]interface{}{
+			"code":    code,
+			"message": fmt.Sprintf(format, args...),
+		})
+	}
+
+	if opts.Width > 0 || opts.Height > 0 {
+		if dims, err := bimg.Size(compressed); err == nil && !reachesBox(dims, opts) {
+			add(warnNotUpscaled, "The image is %dx%d, smaller than the requested size, and was not enlarged", dims.Width, dims.Height)
+		}
+	}
+	if target := uploadTarget(data, opts); len(compressed) > target {
+		add(warnSizeTargetExceeded, "The stored image is %d bytes, over its %d byte target", len(compressed), target)
+	}
+	if gifFrameCount(data) > 1 && gifFrameCount(compressed) <= 1 {
+		add(warnAnimationFlattened, "Only the first frame of the animated GIF was kept")
+	}
+	if opts.DPI > 0 && !supportsDPI(compressed) {
+		add(warnDPINotSet, "The resolution can't be recorded in %s images and was not set", bimg.DetermineImageTypeName(compressed))
+	}
+	return warnings
+}
+
+// reachesBox reports whether an output image fills the requested box as
+// its fit mode should: one requested side for fit=inside, all of them
+// otherwise
+func reachesBox(dims bimg.ImageSize, opts uploadOptions) bool {
+	wide := opts.Width == 0 || dims.Width >= opts.Width
+	tall := opts.Height == 0 || dims.Height >= opts.Height
+	if opts.Fit == fitInside && opts.Width > 0 && opts.Height > 0 {
+		return dims.Width >= opts.Width || dims.Height >= opts.Height
+	}
+	return wide && tall
+}
+
+// setWarningsHeader lists the warning codes in X-Processing-Warnings, for
+// responses whose body is the image itself
+func setWarningsHeader(c *app.RequestContext, warnings []map[string]interface{}) {
+	if len(warnings) == 0 {
+		return
+	}
+	codes := make([]string, len(warnings))
+	for i, w := range warnings {
+		codes[i] = w["code"].(string)
+	}
+	c.Header("X-Processing-Warnings", strings.Join(codes, ","))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/common/hlog"
+	"github.com/cloudwego/hertz/pkg/protocol/consts"
+)
+
+// zipManifestName is the archive entry listing which files were included
+const zipManifestName = "manifest.json"
+
+// validStoredName reports whether name can be a stored filename: a single
+// path element that isn't hidden, so it can't reach outside the storage
+func validStoredName(name string) bool {
+	return name != "" && name == filepath.Base(name) && !strings.HasPrefix(name, ".")
+}
+
+// handleDownloadZip streams a zip archive of the requested stored images,
+// given as a JSON array of filenames. Missing files are skipped and listed
+// in the archive's manifest.json. Files are read one at a time as the
+// archive is written, so only one image is held in memory.
+func handleDownloadZip(ctx context.Context, c *app.RequestContext) {
+	var names []string
+	if err := json.Unmarshal(c.Request.Body(), &names); err != nil {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "Request body must be a JSON array of filenames",
+		})
+		return
+	}
+	if len(names) == 0 {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": "No files to download",
+		})
+		return
+	}
+	if len(names) > cfg.ZipMaxFiles {
+		respond(c, consts.StatusBadRequest, map[string]interface{}{
+			"error": fmt.Sprintf("Too many files: at most %d per archive", cfg.ZipMaxFiles),
+		})
+		return
+	}
+	for _, name := range names {
+		if !validStoredName(name) {
+			respond(c, consts.StatusBadRequest, map[string]interface{}{
+				"error": fmt.Sprintf("Invalid filename: %q", name),
+			})
+			return
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeZip(pw, names))
+	}()
+	c.Header("Content-Disposition", `attachment; filename="images.zip"`)
+	c.SetContentType("application/zip")
+	c.SetBodyStream(pr, -1)
+}
+
+// writeZip writes the archive for names to w. Images are already compressed,
+// so they are stored without deflating them again.
+func writeZip(w io.Writer, names []string) error {
+	zw := zip.NewWriter(w)
+	manifest := map[string][]string{"included": {}, "missing": {}}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		data, err := store.Read(name)
+		if err == errNotFound {
+			manifest["missing"] = append(manifest["missing"], name)
+			continue
+		}
+		if err != nil {
+			hlog.Errorf("zip: failed to read %s: %v", name, err)
+			return err
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			return err // the client went away
+		}
+		manifest["included"] = append(manifest["included"], name)
+	}
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: zipManifestName, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}